@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/google/go-github/v45 v45.2.0
+	github.com/gorilla/mux v1.8.0
 	github.com/livekit/protocol v0.12.0
 	github.com/livekit/server-sdk-go v0.9.2
 	github.com/magefile/mage v1.13.0
@@ -13,4 +14,5 @@ require (
 	github.com/stretchr/testify v1.8.0
 	github.com/xanzy/go-gitlab v0.72.0
 	golang.org/x/oauth2 v0.0.0-20220808172628-8227340efae7
+	google.golang.org/protobuf v1.28.1
 )
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	actionJoin = "join"
+	actionEnd  = "end"
+	actionLink = "link"
+
+	actionAccept  = "accept"
+	actionDecline = "decline"
+)
+
+// meetingAttachments describe the meeting for the clients which can't render custom_livekit
+// posts, such as the mobile apps. Their buttons are served by handleAction.
+func (lkp *LiveKitPlugin) meetingAttachments(meeting *model.Post) []*model.SlackAttachment {
+	if meetingEnded(meeting) {
+		text := "This meeting has ended."
+		if summary, _ := meeting.GetProp("room_summary").(string); summary != "" {
+			text = summary
+		}
+		return []*model.SlackAttachment{{Fallback: "Meeting has ended", Title: meeting.Message, Text: text}}
+	}
+	actionURL := fmt.Sprintf("/plugins/%s/action", pluginID)
+	action := func(id, name, style string) *model.PostAction {
+		return &model.PostAction{
+			Id:    id,
+			Type:  model.PostActionTypeButton,
+			Name:  name,
+			Style: style,
+			Integration: &model.PostActionIntegration{
+				URL:     actionURL,
+				Context: map[string]interface{}{"action": id},
+			},
+		}
+	}
+	text := "LiveKit meeting"
+	if meetingLocked(meeting) {
+		text += ", locked by the host"
+	}
+	if code := codeOf(meeting); code != "" {
+		text += fmt.Sprintf("\nCode: `%s`", code)
+	}
+	return []*model.SlackAttachment{{
+		Fallback: "LiveKit meeting: " + meeting.Message,
+		Title:    meeting.Message,
+		Text:     text,
+		Actions: []*model.PostAction{
+			action(actionJoin, "Join", "primary"),
+			action(actionLink, "Copy link", "default"),
+			action(actionEnd, "End", "danger"),
+		},
+	}}
+}
+
+// handleAction answers the buttons of the meeting attachments.
+func (lkp *LiveKitPlugin) handleAction(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	var request model.PostActionIntegrationRequest
+	if err := decodeBody(r, &request); err != nil {
+		writeError(w, err)
+		return
+	}
+	if request.UserId != userID {
+		writeError(w, newStatusError(http.StatusUnauthorized, "Not authorized"))
+		return
+	}
+	response := &model.PostActionIntegrationResponse{}
+	action, _ := request.Context["action"].(string)
+	switch action {
+	case actionJoin, actionLink:
+		meeting, _, err := lkp.authorizeMeeting(request.PostId, userID)
+		switch {
+		case err != nil:
+			response.EphemeralText = fmt.Sprintf("You can't join this meeting: %s.", err.Error())
+		case action == actionJoin:
+			response.EphemeralText = fmt.Sprintf("[Join the meeting](%s)", lkp.joinLink(meeting.Id))
+		default:
+			response.EphemeralText = fmt.Sprintf("Meeting link:\n```\n%s\n```", lkp.joinLink(meeting.Id))
+		}
+	case actionEnd:
+		_, err := lkp.endMeeting(request.PostId, userID)
+		if err == nil {
+			response.EphemeralText = "The meeting has ended."
+		} else {
+			response.EphemeralText = fmt.Sprintf("The meeting was not ended: %s.", err.Error())
+		}
+	case actionAccept, actionDecline:
+		meetingID, _ := request.Context["meeting_id"].(string)
+		meeting, err := lkp.answerInvite(meetingID, userID, action == actionAccept)
+		switch {
+		case err != nil:
+			response.EphemeralText = fmt.Sprintf("The invitation was not answered: %s.", err.Error())
+		case action == actionAccept:
+			response.EphemeralText = fmt.Sprintf("[Join the meeting](%s)", lkp.joinLink(meeting.Id))
+		default:
+			response.EphemeralText = "You declined the invitation."
+		}
+	default:
+		response.EphemeralText = "Unknown action."
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
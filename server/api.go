@@ -2,16 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"path/filepath"
 	"time"
 
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
+	pluginSDK "github.com/mattermost/mattermost-plugin-api"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin"
+	"github.com/pkg/errors"
 )
 
 // References
@@ -24,6 +23,9 @@ import (
 // https://github.com/blindsidenetworks/mattermost-plugin-bigbluebutton
 // https://developers.mattermost.com/integrate/plugins/server/reference/
 
+// tokenLifetime limits how long a room token stays usable without a refresh.
+const tokenLifetime = 10 * time.Minute
+
 //client4.doFetch requires JSON response
 type fetchResponse struct {
 	Status string      `json:"status"`
@@ -31,7 +33,17 @@ type fetchResponse struct {
 	Data   interface{} `json:"data,omitempty"`
 }
 
-func (lkp *LiveKitPlugin) createPost(channelID, userID, text string, maxParticipants uint32) *model.AppError {
+// meetingOptions are the switches chosen by the host when the meeting is created.
+type meetingOptions struct {
+	// Overflow decides what happens to users who arrive when the meeting is full: "queue"
+	// or "listen", they are turned away by default.
+	Overflow string `json:"overflow,omitempty"`
+	// StartAt schedules the meeting, in milliseconds; Duration is given in minutes.
+	StartAt  int64 `json:"start_at,omitempty"`
+	Duration int   `json:"duration,omitempty"`
+}
+
+func (lkp *LiveKitPlugin) createPost(channelID, userID, text string, maxParticipants uint32, options meetingOptions) (*model.Post, *model.AppError) {
 	post := &model.Post{
 		UserId:    lkp.botUserID,
 		ChannelId: channelID,
@@ -40,157 +52,193 @@ func (lkp *LiveKitPlugin) createPost(channelID, userID, text string, maxParticip
 		Props: map[string]interface{}{
 			"room_capacity": maxParticipants,
 			"room_host":     userID,
-			// "attachments": []*model.SlackAttachment{&model.SlackAttachment{}},
 		},
 	}
+	if options.Overflow != "" {
+		post.AddProp("room_overflow", options.Overflow)
+	}
+	if options.StartAt > 0 {
+		if options.Duration < 1 {
+			options.Duration = 60
+		}
+		post.AddProp("room_start", options.StartAt)
+		post.AddProp("room_duration", options.Duration)
+	}
+	code, err := lkp.reserveCode()
+	if err != nil {
+		return nil, model.NewAppError("createPost", "plugin.livekit.code.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	post.AddProp("room_code", code)
+	model.ParseSlackAttachment(post, lkp.meetingAttachments(post))
 	// lkp.API.SendEphemeralPost(lkp.bot.UserId, post)
 	newRoomPost, appErr := lkp.API.CreatePost(post)
+	event := auditEvent{Action: auditMeetingCreate, ActorID: userID, ChannelID: channelID}
+	if options.StartAt > 0 {
+		event.Action = auditMeetingSchedule
+	}
 	if appErr == nil {
+		lkp.linkCode(code, newRoomPost.Id)
+		event.MeetingID = newRoomPost.Id
+		lkp.audit(event, nil)
+		lkp.recordCreated(newRoomPost, userID)
 		lkp.API.LogInfo("room created", "id", newRoomPost.Id)
-		return nil
+		if options.StartAt > 0 {
+			if err := lkp.scheduleMeeting(newRoomPost, options); err != nil {
+				lkp.API.LogError("meeting schedule failed", "id", newRoomPost.Id, "reason", err.Error())
+			}
+		}
+		return newRoomPost, nil
 	}
-	return appErr
+	lkp.linkCode(code, "")
+	lkp.audit(event, appErr)
+	return nil, appErr
 }
 
-// ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
-func (lkp *LiveKitPlugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
-	reply := fetchResponse{Status: "error"}
-	userID := r.Header.Get("Mattermost-User-ID")
-	if userID == "" {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return
-	}
-	info := fmt.Sprintf("Got %s request on %s", r.Method, r.URL.Path)
-	lkp.API.LogInfo(info)
-	switch r.URL.Path {
-	case "/webhook":
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-	case "/join":
-		var room *livekit.Room
-		tokenRequest := struct {
-			PostID string `json:"post_id"`
-		}{}
-		err := json.NewDecoder(r.Body).Decode(&tokenRequest)
-		if err == nil {
-			post, postAE := lkp.API.GetPost(tokenRequest.PostID)
-			_, cmAE := lkp.API.GetChannelMember(post.ChannelId, userID)
-			tokenUser, userAE := lkp.API.GetUser(userID)
-			if postAE != nil || cmAE != nil || userAE != nil {
-				reply.Error = fmt.Sprintf("%s\n%s\n%s", postAE.DetailedError, cmAE.DetailedError, userAE.DetailedError)
-				json.NewEncoder(w).Encode(reply)
-				return
-			}
-			lkp.API.LogInfo("room token requested", "post_id", tokenRequest.PostID)
-			roomList, err := lkp.master.ListRooms(
-				context.Background(),
-				&livekit.ListRoomsRequest{Names: []string{tokenRequest.PostID}},
-			)
-			if err == nil && len(roomList.Rooms) > 0 {
-				room = roomList.Rooms[0]
-				lkp.API.LogInfo("room found", "name", room.Name)
-			} else {
-				n := post.GetProp("room_capacity").(float64)
-				castedN := uint32(n)
-				newRoom, err := lkp.master.CreateRoom(
-					context.Background(),
-					&livekit.CreateRoomRequest{
-						Name:            tokenRequest.PostID,
-						Metadata:        userID,
-						EmptyTimeout:    300,
-						MaxParticipants: castedN,
-					},
-				)
-				if err == nil {
-					room = newRoom
-					lkp.API.LogInfo("room created", "name", room.Name)
-				} else {
-					lkp.API.LogError("room creation failed", "reason", err.Error())
-				}
-			}
-			accessToken := auth.NewAccessToken(lkp.configuration.ApiKey, lkp.configuration.ApiValue)
-			grant := &auth.VideoGrant{RoomJoin: true, Room: room.Name}
-			userName := tokenUser.GetDisplayName("full_name")
-			accessToken.AddGrant(grant).SetValidFor(time.Hour * 12).SetIdentity(userID).SetName(userName)
-			jwt, err := accessToken.ToJWT()
-			if err == nil {
-				reply.Status = "OK"
-				reply.Data = jwt
-				json.NewEncoder(w).Encode(reply)
-				return
-			}
-		}
-		reply.Error = err.Error()
-		json.NewEncoder(w).Encode(reply)
-	case "/rooms":
-		roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
-		if err == nil {
-			json.NewEncoder(w).Encode(roomList)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	case "/host":
-		// https://github.com/matterpoll/matterpoll/blob/master/server/plugin/api.go#L324
-		// https://github.com/matterpoll/matterpoll/blob/master/server/plugin/api.go#L484
-		// lkp.API.SendEphemeralPost(userID, &model.Post{})
-	case "/create":
-		// https://stackoverflow.com/questions/57096382/response-from-interactive-button-post-is-ignored-in-mattermost
-		roomRequest := struct {
-			ChannelID string `json:"channel_id"`
-			Capacity  uint32 `json:"capacity"`
-			Message   string `json:"message"`
-		}{}
-		err := json.NewDecoder(r.Body).Decode(&roomRequest)
-		if err == nil {
-			channel, appErr := lkp.API.GetChannel(roomRequest.ChannelID)
-			if appErr == nil {
-				member, appErr := lkp.API.GetChannelMember(channel.Id, userID)
-				if appErr == nil {
-					info := fmt.Sprintf("User %s requested new live room for channel %s", member.UserId, member.ChannelId)
-					lkp.API.LogInfo(info)
-					appErr = lkp.createPost(roomRequest.ChannelID, member.UserId, roomRequest.Message, roomRequest.Capacity)
-					if appErr == nil {
-						reply.Status = "OK"
-					} else {
-						reply.Error = appErr.DetailedError
-					}
-					json.NewEncoder(w).Encode(reply)
-					return
-				}
-				http.Error(w, appErr.DetailedError, http.StatusForbidden)
-				return
-			}
-			http.Error(w, appErr.DetailedError, http.StatusBadRequest)
-		} else {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+// getMeeting returns the meeting post with the given id, refusing posts of any other type.
+// Only a missing post is reported as not found, other failures may be temporary.
+func (lkp *LiveKitPlugin) getMeeting(postID string) (*model.Post, error) {
+	post, appErr := lkp.API.GetPost(postID)
+	if appErr != nil && appErr.StatusCode == http.StatusNotFound {
+		return nil, newStatusError(http.StatusNotFound, "meeting not found")
+	}
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to read meeting")
+	}
+	if post.Type != "custom_livekit" {
+		return nil, newStatusError(http.StatusNotFound, "post is not a meeting")
+	}
+	return post, nil
+}
+
+// isHost tells whether the user is allowed to manage the meeting, as its host or a co-host.
+func (lkp *LiveKitPlugin) isHost(meeting *model.Post, userID string) bool {
+	if hostOf(meeting) == userID {
+		return true
+	}
+	for _, coHost := range coHostsOf(meeting) {
+		if coHost == userID {
+			return true
 		}
-	case "/delete":
-		var deleteRequest map[string]string
-		err := json.NewDecoder(r.Body).Decode(&deleteRequest)
-		postID, found := deleteRequest["post_id"]
-		if err == nil && found {
-			info := fmt.Sprintf("User %s requested room deletion from post [%s]", userID, postID)
-			lkp.API.LogInfo(info)
-			appErr := lkp.API.DeletePost(postID)
-			// postHost := post.GetProp("room_host").(string)
-			if appErr == nil {
-				reply.Status = "OK"
-				json.NewEncoder(w).Encode(reply)
-				return
-			}
-			err = fmt.Errorf("%s", appErr.DetailedError)
+	}
+	return false
+}
+
+// meetingEnded tells whether the meeting was closed for good.
+func meetingEnded(meeting *model.Post) bool {
+	status, _ := meeting.GetProp("room_status").(string)
+	return status == "ended"
+}
+
+// authorizeMeeting checks that the meeting is still open and the user may take part in it.
+// Every token is minted only after this check, so it is repeated on each refresh.
+func (lkp *LiveKitPlugin) authorizeMeeting(postID, userID string) (*model.Post, *model.User, error) {
+	meeting, err := lkp.getMeeting(postID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if _, appErr := lkp.API.GetChannelMember(meeting.ChannelId, userID); appErr != nil && !lkp.isInvited(meeting.Id, userID) {
+		if appErr.StatusCode != http.StatusNotFound {
+			return nil, nil, errors.Wrap(appError(appErr), "failed to check channel membership")
 		}
-		reply.Error = err.Error()
-		json.NewEncoder(w).Encode(reply)
-	case "/settings":
-		copy := *lkp.configuration
-		copy.ApiKey = "n/a"
-		copy.ApiValue = "n/a"
-		json.NewEncoder(w).Encode(copy)
-	case "/assets/channel-icon.png":
-		http.ServeFile(w, r, filepath.Join(lkp.bundlePath, "assets", "channel-icon.png"))
-	default:
-		http.NotFound(w, r)
+		return nil, nil, newStatusError(http.StatusForbidden, "you are not a member of this channel")
+	}
+	user, appErr := lkp.API.GetUser(userID)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "user not found")
+	}
+	return meeting, user, nil
+}
+
+// isChannelAdmin tells whether the user administers the channel or the whole system.
+func (lkp *LiveKitPlugin) isChannelAdmin(channelID, userID string) bool {
+	if lkp.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		return true
+	}
+	member, appErr := lkp.API.GetChannelMember(channelID, userID)
+	return appErr == nil && member.SchemeAdmin
+}
+
+// roomToken mints a short-lived access token which lets the user join the named room.
+// Clients are expected to call /refresh before the token expires.
+func (lkp *LiveKitPlugin) roomToken(roomName string, user *model.User) (string, error) {
+	accessToken := auth.NewAccessToken(lkp.configuration.ApiKey, lkp.configuration.ApiValue)
+	grant := &auth.VideoGrant{RoomJoin: true, Room: roomName}
+	userName := user.GetDisplayName("full_name")
+	accessToken.AddGrant(grant).SetValidFor(tokenLifetime).SetIdentity(user.Id).SetName(userName)
+	return accessToken.ToJWT()
+}
+
+// ensureRoom returns the LiveKit room of the meeting, creating it on the first join.
+func (lkp *LiveKitPlugin) ensureRoom(meeting *model.Post, userID string) (*livekit.Room, error) {
+	roomList, err := lkp.master.ListRooms(
+		context.Background(),
+		&livekit.ListRoomsRequest{Names: []string{meeting.Id}},
+	)
+	if err == nil && len(roomList.Rooms) > 0 {
+		lkp.API.LogInfo("room found", "name", roomList.Rooms[0].Name)
+		return roomList.Rooms[0], nil
 	}
+	metadata, err := lkp.roomMetadata(meeting)
+	if err != nil {
+		return nil, err
+	}
+	// Saved before the room exists, as LiveKit may send room_started before CreateRoom returns.
+	if _, err := lkp.sdk.KV.Set(roomStarterKeyPrefix+meeting.Id, userID, pluginSDK.SetExpiry(time.Hour)); err != nil {
+		lkp.API.LogWarn("room starter not saved", "room", meeting.Id, "reason", err.Error())
+	}
+	room, err := lkp.master.CreateRoom(
+		context.Background(),
+		&livekit.CreateRoomRequest{
+			Name:            meeting.Id,
+			Metadata:        metadata,
+			EmptyTimeout:    300,
+			MaxParticipants: roomLimit(meeting),
+		},
+	)
+	if err != nil {
+		lkp.API.LogError("room creation failed", "reason", err.Error())
+		return nil, newStatusError(http.StatusBadGateway, "room creation failed: %s", err.Error())
+	}
+	lkp.API.LogInfo("room created", "name", room.Name)
+	return room, nil
 }
 
-// See https://developers.mattermost.com/extend/plugins/server/reference/
+// joinMeeting checks that the user may join the meeting and mints their room token.
+// Refused joins are audited as well.
+func (lkp *LiveKitPlugin) joinMeeting(postID, userID string) (jwt string, err error) {
+	event := auditEvent{Action: auditMeetingJoin, ActorID: userID, MeetingID: postID}
+	defer func() { lkp.audit(event, err) }()
+	post, tokenUser, err := lkp.authorizeMeeting(postID, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := lkp.checkLock(post, userID); err != nil {
+		return "", err
+	}
+	listener, err := lkp.checkCapacity(post, userID)
+	if err != nil {
+		return "", err
+	}
+	lkp.API.LogInfo("room token requested", "post_id", postID)
+	room, err := lkp.ensureRoom(post, userID)
+	if err != nil {
+		return "", err
+	}
+	go lkp.bridgeRoom(post)
+	if listener {
+		event.Details = "listen-only"
+		return lkp.listenerToken(room.Name, tokenUser)
+	}
+	return lkp.roomToken(room.Name, tokenUser)
+}
+
+// ServeHTTP hands the request to the router, which is built on first use.
+func (lkp *LiveKitPlugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	lkp.routerOnce.Do(func() {
+		lkp.router = lkp.newRouter()
+	})
+	lkp.router.ServeHTTP(w, r)
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -24,5 +34,657 @@ func TestServeHTTP(t *testing.T) {
 	assert.Nil(err)
 	bodyString := string(bodyBytes)
 
-	assert.Equal("Not authorized\n", bodyString)
+	assert.Equal(http.StatusUnauthorized, result.StatusCode)
+	assert.Equal("{\"status\":\"error\",\"error\":\"Not authorized\"}\n", bodyString)
+}
+
+const (
+	testChannelID = "channelid"
+	testUserID    = "userid"
+	testMeetingID = "meetingid"
+)
+
+func testMeeting(capacity float64) *model.Post {
+	return &model.Post{
+		Id:        testMeetingID,
+		UserId:    "botuserid",
+		ChannelId: testChannelID,
+		Message:   "Standup",
+		Type:      "custom_livekit",
+		Props: map[string]interface{}{
+			"room_capacity": capacity,
+			"room_host":     testUserID,
+		},
+	}
+}
+
+func serve(lkp *LiveKitPlugin, method, path, userID, body string) (*httptest.ResponseRecorder, fetchResponse) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if userID != "" {
+		r.Header.Set("Mattermost-User-ID", userID)
+	}
+	lkp.ServeHTTP(nil, w, r)
+	var reply fetchResponse
+	json.Unmarshal(w.Body.Bytes(), &reply)
+	return w, reply
+}
+
+// expectMember mocks what authorizeMeeting and the room metadata read about the meeting.
+func expectMember(api *testAPI, meeting *model.Post) {
+	api.On("GetPost", meeting.Id).Return(meeting, nil)
+	api.On("GetChannelMember", testChannelID, testUserID).Return(&model.ChannelMember{ChannelId: testChannelID, UserId: testUserID}, nil)
+	api.On("GetUser", testUserID).Return(&model.User{Id: testUserID, Username: "alice", FirstName: "Alice"}, nil)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID, TeamId: "teamid"}, nil)
+}
+
+func TestCreateMeeting(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID}, nil)
+	api.On("HasPermissionToChannel", testUserID, testChannelID, model.PermissionCreatePost).Return(true)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		post.Id = testMeetingID
+		return post
+	}, nil)
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings", testUserID, `{"channel_id":"channelid","message":"Standup","capacity":5}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "OK", reply.Status)
+	post := reply.Data.(map[string]interface{})
+	assert.Equal(t, testMeetingID, post["id"])
+	assert.Equal(t, "custom_livekit", post["type"])
+	api.AssertExpectations(t)
+}
+
+func TestCreateMeetingForbidden(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID}, nil)
+	api.On("HasPermissionToChannel", testUserID, testChannelID, model.PermissionCreatePost).Return(false)
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings", testUserID, `{"channel_id":"channelid","message":"Standup"}`)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "error", reply.Status)
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+}
+
+func TestJoinCreatesRoom(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(5))
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, reply.Data.(map[string]interface{})["token"])
+	room := fake.room(testMeetingID)
+	require.NotNil(t, room)
+	assert.EqualValues(t, 5, room.MaxParticipants)
+	var metadata roomMetadata
+	require.NoError(t, json.Unmarshal([]byte(room.Metadata), &metadata))
+	assert.Equal(t, roomMetadataVersion, metadata.Version)
+	assert.Equal(t, "Standup", metadata.Topic)
+	assert.Equal(t, "teamid", metadata.TeamID)
+	assert.Equal(t, testUserID, metadata.HostID)
+}
+
+func TestJoinExistingRoom(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, fake.callCount("CreateRoom"))
+}
+
+func TestJoinFullMeeting(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(1))
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, "otheruserid")
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "meeting is full", reply.Error)
+}
+
+func TestRefreshDoesNotSkipTheLock(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	meeting := testMeeting(0)
+	meeting.AddProp("room_locked", true)
+	expectMember(api, meeting)
+	for _, userID := range []string{"bobid", "carolid"} {
+		api.On("GetChannelMember", testChannelID, userID).Return(&model.ChannelMember{ChannelId: testChannelID, UserId: userID}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID, Username: userID}, nil)
+		api.On("HasPermissionTo", userID, model.PermissionManageSystem).Return(false)
+	}
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, "bobid")
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "bobid", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "carolid", "")
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Equal(t, "meeting is locked, ask the host to let you in", reply.Error)
+
+	_, err := lkp.sdk.KV.Set(breakoutReturnKeyPrefix+testMeetingID, []string{"carolid"})
+	require.NoError(t, err)
+	w, _ = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "carolid", "")
+	assert.Equal(t, http.StatusOK, w.Code, "back from a breakout room")
+}
+
+func TestRefreshDoesNotSkipTheCapacity(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	meeting := testMeeting(1)
+	expectMember(api, meeting)
+	api.On("GetChannelMember", testChannelID, "bobid").Return(&model.ChannelMember{ChannelId: testChannelID, UserId: "bobid"}, nil)
+	api.On("GetUser", "bobid").Return(&model.User{Id: "bobid", Username: "bob"}, nil)
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, "otheruserid")
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "bobid", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "meeting is full", reply.Error)
+
+	_, err := lkp.sdk.KV.Set(breakoutReturnKeyPrefix+testMeetingID, []string{"bobid"})
+	require.NoError(t, err)
+	w, reply = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "bobid", "")
+	assert.Equal(t, http.StatusConflict, w.Code, "back from a breakout room")
+	assert.Equal(t, "meeting is full", reply.Error)
+
+	meeting.AddProp("room_overflow", overflowListen)
+	w, _ = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", "bobid", "")
+	assert.Equal(t, http.StatusOK, w.Code, "listen-only seat")
+}
+
+func TestJoinLiveKitFailure(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	fake.fail("CreateRoom", errors.New("no capacity left on the node"))
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, reply.Error, "room creation failed")
+}
+
+func TestJoinUnknownMeeting(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetPost", "missing").Return(nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound))
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/missing/join", testUserID, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "meeting not found", reply.Error)
+}
+
+func TestDeleteMeeting(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetPost", testMeetingID).Return(testMeeting(0), nil)
+	api.On("DeletePost", testMeetingID).Return(nil)
+
+	w, _ := serve(lkp, http.MethodDelete, "/api/v1/meetings/meetingid", testUserID, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	api.AssertExpectations(t)
+}
+
+func TestDeleteMeetingByOthers(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetPost", testMeetingID).Return(testMeeting(0), nil)
+	api.On("HasPermissionTo", "strangerid", model.PermissionManageSystem).Return(false)
+	api.On("GetChannelMember", testChannelID, "strangerid").Return(&model.ChannelMember{}, nil)
+
+	w, _ := serve(lkp, http.MethodDelete, "/api/v1/meetings/meetingid", "strangerid", "")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	api.AssertNotCalled(t, "DeletePost", mock.Anything)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	lkp, _, _ := newTestPlugin(t)
+
+	w, reply := serve(lkp, http.MethodGet, "/api/v1/meetings/meetingid/join", testUserID, "")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "error", reply.Status)
+}
+
+func TestExecuteCommandCreatesMeeting(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID}, nil)
+	api.On("HasPermissionToChannel", testUserID, testChannelID, model.PermissionCreatePost).Return(true)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == "Standup" && post.GetProp("room_capacity") == uint32(5)
+	})).Return(testMeeting(5), nil)
+
+	response, appErr := lkp.ExecuteCommand(nil, &model.CommandArgs{
+		Command:   `/liveroom "Standup" 5`,
+		ChannelId: testChannelID,
+		UserId:    testUserID,
+	})
+
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "maxParticipants = 5")
+	api.AssertExpectations(t)
+}
+
+func TestJoinIsAudited(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	api.On("GetPost", "missing").Return(nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound))
+
+	serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+	serve(lkp, http.MethodPost, "/api/v1/meetings/missing/join", testUserID, "")
+
+	now := model.GetMillis()
+	events, err := lkp.queryAudit(auditQuery{Since: now - 60000, Until: now, Action: auditMeetingJoin, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "missing", events[0].MeetingID)
+	assert.Equal(t, "meeting not found", events[0].Result)
+	assert.Equal(t, testMeetingID, events[1].MeetingID)
+	assert.Equal(t, testChannelID, events[1].ChannelID)
+	assert.Equal(t, testUserID, events[1].ActorID)
+	assert.Equal(t, auditResultOK, events[1].Result)
+
+	api.On("HasPermissionTo", testUserID, model.PermissionManageSystem).Return(false)
+	w, _ := serve(lkp, http.MethodGet, "/api/v1/audit", testUserID, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuditIndexedByDay(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	lkp.configuration.AuditRetentionDays = 5
+	for i := 0; i < 3*kvListPageSize; i++ {
+		api.kv[fmt.Sprintf("audit_%04d", i)] = []byte("{}")
+	}
+	old := time.Now().AddDate(0, 0, -10)
+	key, err := lkp.nextAuditKey(old)
+	require.NoError(t, err)
+	_, err = lkp.sdk.KV.Set(key, &auditEvent{ID: "old", CreateAt: old.UnixMilli(), Action: auditMeetingEnd})
+	require.NoError(t, err)
+	for _, target := range []string{"first", "second", "third"} {
+		lkp.audit(auditEvent{Action: auditMeetingEnd, TargetID: target}, nil)
+	}
+
+	now := model.GetMillis()
+	events, err := lkp.queryAudit(auditQuery{Since: old.UnixMilli(), Until: now, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, "third", events[0].TargetID)
+	assert.Equal(t, "second", events[1].TargetID)
+	assert.Equal(t, "first", events[2].TargetID)
+	assert.Equal(t, "old", events[3].ID)
+
+	lkp.cleanupAudit()
+
+	events, err = lkp.queryAudit(auditQuery{Since: old.UnixMilli(), Until: now, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.NotContains(t, api.kv, key)
+	days, err := lkp.getAuditDays()
+	require.NoError(t, err)
+	assert.Equal(t, []string{time.Now().UTC().Format(auditDayFormat)}, days)
+}
+
+func TestRoomStartSkipsTheStarter(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	api.On("GetChannelMember", testChannelID, "bobid").Return(&model.ChannelMember{ChannelId: testChannelID, UserId: "bobid"}, nil)
+	api.On("GetDirectChannel", "bobid", "botuserid").Return(&model.Channel{Id: "directid"}, nil)
+	api.On("CreatePost", mock.Anything).Return(&model.Post{Id: "dmid"}, nil)
+	siteURL := "https://chat.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	require.NoError(t, lkp.subscribe(testChannelID, testUserID, true))
+	require.NoError(t, lkp.subscribe(testChannelID, "bobid", true))
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", testUserID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	lkp.onRoomStarted(fake.room(testMeetingID))
+
+	api.AssertCalled(t, "GetDirectChannel", "bobid", "botuserid")
+	api.AssertNotCalled(t, "GetDirectChannel", testUserID, "botuserid")
+	assert.NotContains(t, api.kv, roomStarterKeyPrefix+testMeetingID)
+}
+
+func TestMeetingHistory(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	meeting := testMeeting(0)
+	siteURL := "https://chat.example.com"
+	api.On("GetPost", testMeetingID).Return(meeting, nil)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID, DisplayName: "Team"}, nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+
+	room := &livekit.Room{Name: testMeetingID}
+	breakout := &livekit.Room{Name: testMeetingID + "-breakout-1"}
+	participant := &livekit.ParticipantInfo{Identity: "otheruserid"}
+	lkp.recordCreated(meeting, testUserID)
+	lkp.trackAttendance(room, participant, true)
+	lkp.trackAttendance(breakout, participant, true)
+	lkp.trackAttendance(room, participant, false)
+	lkp.trackAttendance(room, &livekit.ParticipantInfo{Identity: "botuserid"}, true)
+
+	now := model.GetMillis()
+	history, err := lkp.meetingHistory("otheruserid", now-60000, now, 0, 20)
+	require.NoError(t, err)
+	require.Len(t, history.Entries, 1)
+	entry := history.Entries[0]
+	assert.True(t, entry.Joined)
+	assert.False(t, entry.Created)
+	assert.Equal(t, "Team", entry.ChannelName)
+	assert.Equal(t, "Standup", entry.Topic)
+	assert.Equal(t, siteURL+"/_redirect/pl/"+testMeetingID, entry.Permalink)
+
+	var record *attendance
+	require.NoError(t, lkp.sdk.KV.Get(historyKey("otheruserid", testMeetingID), &record))
+	assert.Equal(t, 1, record.Sessions, "still in the breakout room")
+
+	w, reply := serve(lkp, http.MethodGet, "/api/v1/history", testUserID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 1, reply.Data.(map[string]interface{})["total"])
+
+	history, err = lkp.meetingHistory("botuserid", now-60000, now, 0, 20)
+	require.NoError(t, err)
+	assert.Zero(t, history.Total)
+
+	for i := 0; i < 3*kvListPageSize; i++ {
+		api.kv[fmt.Sprintf("a_%04d", i)] = []byte("{}")
+	}
+	history, err = lkp.meetingHistory("otheruserid", now-60000, now, 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.Total, "found past the first page of keys")
+	meetingIDs, err := lkp.getHistoryIndex("otheruserid")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testMeetingID}, meetingIDs)
+
+	_, err = lkp.sdk.KV.Set(historyKey("bobid", testMeetingID), &attendance{MeetingID: testMeetingID, ChannelID: testChannelID, Joined: true, At: now})
+	require.NoError(t, err)
+	history, err = lkp.meetingHistory("bobid", now-60000, now, 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.Total, "saved before the index")
+	assert.Contains(t, api.kv, historyIndexKeyPrefix+"bobid")
+}
+
+func TestInvitedUserJoins(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	notMember := model.NewAppError("GetChannelMember", "app.channel.get_member.missing.app_error", nil, "", http.StatusNotFound)
+	api.On("GetChannelMember", testChannelID, "guestid").Return(nil, notMember)
+	api.On("GetChannelMember", testChannelID, "strangerid").Return(nil, notMember)
+	api.On("GetUser", "guestid").Return(&model.User{Id: "guestid", Username: "guest"}, nil)
+	api.On("GetDirectChannel", "guestid", "botuserid").Return(&model.Channel{Id: "directid"}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "postid"}, nil)
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", "guestid", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/invites", testUserID, `{"user_ids":["guestid"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "directid" && len(post.Attachments()) == 1
+	}))
+
+	w, _ = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/join", "guestid", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := lkp.answerInvite(testMeetingID, "guestid", true)
+	require.NoError(t, err)
+	_, reply := serve(lkp, http.MethodGet, "/api/v1/meetings/meetingid/invites", testUserID, "")
+	invites := reply.Data.([]interface{})
+	require.Len(t, invites, 1)
+	assert.Equal(t, "accepted", invites[0].(map[string]interface{})["status"])
+
+	api.On("HasPermissionTo", "strangerid", model.PermissionManageSystem).Return(false)
+	w, _ = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/invites", "strangerid", `{"user_ids":["strangerid"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	_, err = lkp.answerInvite(testMeetingID, "strangerid", true)
+	assert.Equal(t, http.StatusNotFound, errorStatus(err))
+}
+
+func TestMeetingCode(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	meeting := testMeeting(0)
+	api.On("GetChannel", testChannelID).Return(&model.Channel{Id: testChannelID}, nil)
+	api.On("HasPermissionToChannel", testUserID, testChannelID, model.PermissionCreatePost).Return(true)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		meeting.AddProp("room_code", post.GetProp("room_code"))
+		return meeting
+	}, nil)
+	expectMember(api, meeting)
+	siteURL := "https://chat.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings", testUserID, `{"channel_id":"channelid","message":"Standup"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	code := codeOf(meeting)
+	assert.Regexp(t, `^[a-z]{3}-[a-z]{4}-[a-z]{3}$`, code)
+
+	w, reply := serve(lkp, http.MethodGet, "/api/v1/codes/"+strings.ToUpper(strings.ReplaceAll(code, "-", "")), testUserID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testMeetingID, reply.Data.(map[string]interface{})["meeting_id"])
+
+	text := lkp.executeJoin(&model.CommandArgs{UserId: testUserID}, []string{code})
+	assert.Contains(t, text, "/meet/"+code)
+
+	w, reply = serve(lkp, http.MethodGet, "/api/v1/codes/aaa-aaaa-aaa", testUserID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "no meeting has the code aaa-aaaa-aaa", reply.Error)
+}
+
+func TestBreakoutRoomsAreBounded(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, testUserID)
+
+	w, reply := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/breakout", testUserID, `{"rooms":1000000,"minutes":10}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "at most 50 breakout rooms can be opened", reply.Error)
+
+	w, reply = serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/breakout", testUserID, `{"rooms":2,"minutes":10}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "1 participants can't fill 2 breakout rooms", reply.Error)
+	assert.Equal(t, 1, fake.callCount("CreateRoom"))
+}
+
+func TestHostRemovesParticipant(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, "bobid")
+
+	w, _ := serve(lkp, http.MethodDelete, "/api/v1/meetings/meetingid/participants/userid", "bobid", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = serve(lkp, http.MethodDelete, "/api/v1/meetings/meetingid/participants/bobid", testUserID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err := fake.GetParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: testMeetingID, Identity: "bobid"})
+	assert.Error(t, err)
+}
+
+func TestCleanupRecordingsPastFirstKeyPage(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	lkp.configuration.RecordingRetentionDays = 1
+	for i := 0; i < 3*kvListPageSize; i++ {
+		api.kv[fmt.Sprintf("audit_%04d", i)] = []byte("{}")
+	}
+	old := model.GetMillis() - 2*24*time.Hour.Milliseconds()
+	for _, egressID := range []string{"e1", "e2", "e3"} {
+		_, err := lkp.sdk.KV.Set(recordingKeyPrefix+egressID, &recording{
+			EgressID:  egressID,
+			MeetingID: testMeetingID,
+			ChannelID: testChannelID,
+			Filename:  "/nonexistent/" + egressID + ".mp4",
+			CreateAt:  old,
+		})
+		require.NoError(t, err)
+	}
+
+	lkp.cleanupRecordings()
+
+	keys, err := lkp.listKeys(recordingKeyPrefix)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = lkp.listKeys("audit_0")
+	require.NoError(t, err)
+	assert.Len(t, keys, 3*kvListPageSize, "other keys stay")
+	events, err := lkp.queryAudit(auditQuery{Until: model.GetMillis(), Action: auditRecordingDelete, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, events, 3, "each deletion is audited")
+}
+
+func TestFailedRecordingDeletionIsAuditedOnce(t *testing.T) {
+	lkp, _, _ := newTestPlugin(t)
+	lkp.configuration.RecordingRetentionDays = 1
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer storage.Close()
+	_, err := lkp.sdk.KV.Set(recordingKeyPrefix+"e1", &recording{
+		EgressID:  "e1",
+		MeetingID: testMeetingID,
+		ChannelID: testChannelID,
+		Filename:  "e1.mp4",
+		Location:  storage.URL + "/e1.mp4",
+		CreateAt:  model.GetMillis() - 2*24*time.Hour.Milliseconds(),
+	})
+	require.NoError(t, err)
+
+	lkp.cleanupRecordings()
+	lkp.cleanupRecordings()
+
+	var record *recording
+	require.NoError(t, lkp.sdk.KV.Get(recordingKeyPrefix+"e1", &record))
+	require.NotNil(t, record)
+	assert.NotZero(t, record.DeleteFailedAt)
+	events, err := lkp.queryAudit(auditQuery{Until: model.GetMillis(), Action: auditRecordingDelete, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestRetentionNeedsSystemAdminToShorten(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	lkp.configuration.RecordingRetentionDays = 30
+	api.On("HasPermissionTo", testUserID, model.PermissionManageSystem).Return(false)
+	api.On("HasPermissionTo", "adminid", model.PermissionManageSystem).Return(true)
+	api.On("GetChannelMember", testChannelID, testUserID).Return(&model.ChannelMember{SchemeAdmin: true}, nil)
+	channelAdmin := &model.CommandArgs{UserId: testUserID, ChannelId: testChannelID}
+	systemAdmin := &model.CommandArgs{UserId: "adminid", ChannelId: testChannelID}
+
+	assert.Equal(t, "Recordings of this channel are deleted after 60 days now.", lkp.executeRetention(channelAdmin, []string{"60"}))
+	assert.Contains(t, lkp.executeRetention(channelAdmin, []string{"10"}), "Only system admins")
+	assert.Contains(t, lkp.executeRetention(channelAdmin, []string{"hold"}), "Only system admins")
+
+	assert.Contains(t, lkp.executeRetention(systemAdmin, []string{"hold"}), "legal hold")
+	assert.Contains(t, lkp.executeRetention(channelAdmin, []string{"default"}), "Only system admins")
+	assert.Contains(t, lkp.executeRetention(channelAdmin, []string{"forever"}), "Only system admins")
+	policy, _, err := lkp.getRetention(testChannelID)
+	require.NoError(t, err)
+	assert.True(t, policy.LegalHold)
+}
+
+func TestListRoomsOfMemberChannels(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	expectMember(api, testMeeting(0))
+	private := testMeeting(0)
+	private.Id = "privateid"
+	private.ChannelId = "privatechannelid"
+	api.On("GetPost", "privateid").Return(private, nil)
+	api.On("GetChannelMember", "privatechannelid", testUserID).Return(nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound))
+	api.On("HasPermissionTo", testUserID, model.PermissionManageSystem).Return(false)
+	api.On("HasPermissionTo", "adminid", model.PermissionManageSystem).Return(true)
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: "privateid"})
+
+	w, reply := serve(lkp, http.MethodGet, "/api/v1/rooms", testUserID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	rooms := reply.Data.([]interface{})
+	require.Len(t, rooms, 1)
+	assert.Equal(t, testMeetingID, rooms[0].(map[string]interface{})["name"])
+
+	_, reply = serve(lkp, http.MethodGet, "/api/v1/rooms", "adminid", "")
+	assert.Len(t, reply.Data.([]interface{}), 2)
+}
+
+func TestEditCantLiftLimitOfOpenRoom(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	meeting := testMeeting(5)
+	expectMember(api, meeting)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post { return post }, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID, MaxParticipants: 5})
+
+	w, _ := serve(lkp, http.MethodPatch, "/api/v1/meetings/meetingid", testUserID, `{"capacity":8}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint32(8), fake.room(testMeetingID).MaxParticipants)
+
+	w, _ = serve(lkp, http.MethodPatch, "/api/v1/meetings/meetingid", testUserID, `{"capacity":0}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w, _ = serve(lkp, http.MethodPatch, "/api/v1/meetings/meetingid", testUserID, `{"overflow":"listen"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, uint32(8), fake.room(testMeetingID).MaxParticipants)
+
+	fake.DeleteRoom(context.Background(), &livekit.DeleteRoomRequest{Room: testMeetingID})
+	w, _ = serve(lkp, http.MethodPatch, "/api/v1/meetings/meetingid", testUserID, `{"overflow":"listen"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReconcileClosesLivePostsPastFirstKeyPage(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	meeting := testMeeting(0)
+	meeting.AddProp("room_live", true)
+	expectMember(api, meeting)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post { return post }, nil)
+	for i := 0; i < 2*kvListPageSize; i++ {
+		api.kv[fmt.Sprintf("history_%04d", i)] = []byte("{}")
+	}
+	_, err := lkp.sdk.KV.Set(liveKeyPrefix+testMeetingID, true)
+	require.NoError(t, err)
+
+	lkp.reconcileRooms()
+
+	assert.False(t, meetingLive(meeting))
+	keys, err := lkp.listKeys(liveKeyPrefix)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestMeetingReadFailureKeepsTheRoom(t *testing.T) {
+	lkp, api, fake := newTestPlugin(t)
+	api.On("GetPost", testMeetingID).Return(nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusInternalServerError))
+	fake.CreateRoom(context.Background(), &livekit.CreateRoomRequest{Name: testMeetingID})
+	fake.join(testMeetingID, testUserID)
+	_, err := lkp.sdk.KV.Set(liveKeyPrefix+testMeetingID, true)
+	require.NoError(t, err)
+
+	lkp.reconcileRooms()
+
+	assert.NotNil(t, fake.room(testMeetingID))
+	keys, err := lkp.listKeys(liveKeyPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{liveKeyPrefix + testMeetingID}, keys)
+
+	w, _ := serve(lkp, http.MethodPost, "/api/v1/meetings/meetingid/refresh", testUserID, "")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Zero(t, fake.callCount("RemoveParticipant"))
+}
+
+func TestEndedMeetingIsNotMarkedLive(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	ended := testMeeting(0)
+	ended.AddProp("room_status", "ended")
+	expectMember(api, ended)
+	stale := testMeeting(0)
+
+	changed, err := lkp.setLive(stale, true)
+
+	require.NoError(t, err)
+	assert.False(t, changed)
+	api.AssertNotCalled(t, "UpdatePost", mock.Anything)
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// Audit events are numbered per day: audit_<day>_<n> is the n-th event of the day and
+// audit_count_<day> the number of events, so a query reads the events without listing the KV
+// store. audit_days lists the days which have events, for the queries and the retention job.
+const (
+	auditKeyPrefix      = "audit_"
+	auditCountKeyPrefix = "audit_count_"
+	auditDaysKey        = "audit_days"
+	auditDayFormat      = "20060102"
+	auditResultOK       = "success"
+)
+
+const (
+	auditMeetingCreate     = "meeting.create"
+	auditMeetingSchedule   = "meeting.schedule"
+	auditMeetingJoin       = "meeting.join"
+	auditMeetingEdit       = "meeting.edit"
+	auditMeetingLock       = "meeting.lock"
+	auditMeetingUnlock     = "meeting.unlock"
+	auditMeetingEnd        = "meeting.end"
+	auditMeetingDelete     = "meeting.delete"
+	auditMeetingInvite     = "meeting.invite"
+	auditInviteAccept      = "invite.accept"
+	auditInviteDecline     = "invite.decline"
+	auditHostTransfer      = "host.transfer"
+	auditHostFallback      = "host.fallback"
+	auditCoHostAdd         = "cohost.add"
+	auditCoHostRemove      = "cohost.remove"
+	auditParticipantRemove = "participant.remove"
+	auditBreakoutStart     = "breakout.start"
+	auditBreakoutEnd       = "breakout.end"
+	auditRecordingFinish   = "recording.finish"
+	auditRecordingDelete   = "recording.delete"
+	auditRetentionChange   = "retention.change"
+)
+
+// auditEvent is one entry of the audit trail. The actor is empty for what the plugin or
+// LiveKit did on their own.
+type auditEvent struct {
+	ID        string `json:"id"`
+	CreateAt  int64  `json:"create_at"`
+	ActorID   string `json:"actor_id,omitempty"`
+	Action    string `json:"action"`
+	MeetingID string `json:"meeting_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	TargetID  string `json:"target_id,omitempty"`
+	Details   string `json:"details,omitempty"`
+	Result    string `json:"result"`
+}
+
+// auditQuery filters the audit trail, the times are in milliseconds.
+type auditQuery struct {
+	Since     int64
+	Until     int64
+	ActorID   string
+	Action    string
+	MeetingID string
+	ChannelID string
+	Limit     int
+}
+
+func (query auditQuery) matches(event *auditEvent) bool {
+	return event.CreateAt >= query.Since && event.CreateAt <= query.Until &&
+		(query.ActorID == "" || event.ActorID == query.ActorID) &&
+		(query.Action == "" || event.Action == query.Action) &&
+		(query.MeetingID == "" || event.MeetingID == query.MeetingID) &&
+		(query.ChannelID == "" || event.ChannelID == query.ChannelID)
+}
+
+func auditKey(day string, number int) string {
+	return fmt.Sprintf("%s%s_%09d", auditKeyPrefix, day, number)
+}
+
+// nextAuditKey numbers the event within its day, and adds the day to the index on its first
+// event.
+func (lkp *LiveKitPlugin) nextAuditKey(at time.Time) (string, error) {
+	day := at.UTC().Format(auditDayFormat)
+	number := 0
+	err := lkp.sdk.KV.SetAtomicWithRetries(auditCountKeyPrefix+day, func(oldValue []byte) (interface{}, error) {
+		number = 0
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &number); err != nil {
+				return nil, err
+			}
+		}
+		number++
+		return number, nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to number audit event")
+	}
+	if number == 1 {
+		err = lkp.updateAuditDays(func(days []string) []string {
+			for _, known := range days {
+				if known == day {
+					return days
+				}
+			}
+			days = append(days, day)
+			sort.Strings(days)
+			return days
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return auditKey(day, number), nil
+}
+
+func (lkp *LiveKitPlugin) getAuditDays() ([]string, error) {
+	days := []string{}
+	if err := lkp.sdk.KV.Get(auditDaysKey, &days); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit days")
+	}
+	return days, nil
+}
+
+func (lkp *LiveKitPlugin) updateAuditDays(update func([]string) []string) error {
+	err := lkp.sdk.KV.SetAtomicWithRetries(auditDaysKey, func(oldValue []byte) (interface{}, error) {
+		days := []string{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &days); err != nil {
+				return nil, err
+			}
+		}
+		return update(days), nil
+	})
+	return errors.Wrap(err, "failed to save audit days")
+}
+
+func (lkp *LiveKitPlugin) auditCount(day string) (int, error) {
+	count := 0
+	if err := lkp.sdk.KV.Get(auditCountKeyPrefix+day, &count); err != nil {
+		return 0, errors.Wrap(err, "failed to read audit event count")
+	}
+	return count, nil
+}
+
+// audit records the outcome of an action. Failing to record it is logged, never returned,
+// so the trail can't break the action itself.
+func (lkp *LiveKitPlugin) audit(event auditEvent, err error) {
+	now := time.Now()
+	event.ID = model.NewId()
+	event.CreateAt = model.GetMillisForTime(now)
+	event.Result = auditResultOK
+	if err != nil {
+		event.Result = err.Error()
+	}
+	if event.ChannelID == "" && event.MeetingID != "" {
+		if meeting, appErr := lkp.API.GetPost(event.MeetingID); appErr == nil {
+			event.ChannelID = meeting.ChannelId
+		}
+	}
+	key, err := lkp.nextAuditKey(now)
+	if err == nil {
+		_, err = lkp.sdk.KV.Set(key, event)
+	}
+	if err != nil {
+		lkp.API.LogError("audit event not saved", "action", event.Action, "meeting", event.MeetingID, "reason", err.Error())
+	}
+	if auditChannelID := lkp.getConfiguration().AuditChannelID; auditChannelID != "" {
+		post := &model.Post{UserId: lkp.botUserID, ChannelId: auditChannelID, Message: lkp.auditLine(&event)}
+		if _, appErr := lkp.API.CreatePost(post); appErr != nil {
+			lkp.API.LogError("audit event not posted", "channel", auditChannelID, "reason", appErr.Error())
+		}
+	}
+}
+
+// auditLine describes the event in a single line of Markdown.
+func (lkp *LiveKitPlugin) auditLine(event *auditEvent) string {
+	actor := "The plugin"
+	if event.ActorID != "" {
+		actor = lkp.mention(event.ActorID)
+	}
+	line := fmt.Sprintf("%s %s `%s`", model.GetTimeForMillis(event.CreateAt).UTC().Format("2006-01-02 15:04:05 MST"), actor, event.Action)
+	if event.MeetingID != "" {
+		line += fmt.Sprintf(" meeting `%s`", event.MeetingID)
+	}
+	if event.ChannelID != "" {
+		if channel, appErr := lkp.API.GetChannel(event.ChannelID); appErr == nil {
+			line += " in ~" + channel.Name
+		}
+	}
+	if event.TargetID != "" {
+		line += ", target " + lkp.mention(event.TargetID)
+	}
+	if event.Details != "" {
+		line += ", " + event.Details
+	}
+	return line + ": " + event.Result
+}
+
+// queryAudit returns the matching events, newest first.
+func (lkp *LiveKitPlugin) queryAudit(query auditQuery) ([]*auditEvent, error) {
+	days, err := lkp.getAuditDays()
+	if err != nil {
+		return nil, err
+	}
+	events := []*auditEvent{}
+	first := model.GetTimeForMillis(query.Since).UTC().Format(auditDayFormat)
+	last := model.GetTimeForMillis(query.Until).UTC().Format(auditDayFormat)
+	for i := len(days) - 1; i >= 0 && days[i] >= first; i-- {
+		if days[i] > last {
+			continue
+		}
+		count, err := lkp.auditCount(days[i])
+		if err != nil {
+			return nil, err
+		}
+		for number := count; number > 0; number-- {
+			var event *auditEvent
+			if err := lkp.sdk.KV.Get(auditKey(days[i], number), &event); err != nil {
+				return nil, errors.Wrap(err, "failed to read audit event")
+			}
+			if event == nil || !query.matches(event) {
+				continue
+			}
+			events = append(events, event)
+			if len(events) == query.Limit {
+				return events, nil
+			}
+		}
+	}
+	return events, nil
+}
+
+// cleanupAudit is the scheduled job dropping the days of audit events past the retention.
+func (lkp *LiveKitPlugin) cleanupAudit() {
+	retention := lkp.getConfiguration().AuditRetentionDays
+	if retention <= 0 {
+		return
+	}
+	days, err := lkp.getAuditDays()
+	if err != nil {
+		lkp.API.LogError("audit days not read", "reason", err.Error())
+		return
+	}
+	oldest := time.Now().UTC().AddDate(0, 0, -retention).Format(auditDayFormat)
+	deleted := 0
+	for _, day := range days {
+		if day >= oldest {
+			break
+		}
+		if err := lkp.deleteAuditDay(day); err != nil {
+			lkp.API.LogError("audit events not deleted", "day", day, "reason", err.Error())
+			break
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		lkp.API.LogInfo("expired audit events deleted", "days", deleted)
+	}
+}
+
+// deleteAuditDay deletes the events of the day, then the day itself from the index.
+func (lkp *LiveKitPlugin) deleteAuditDay(day string) error {
+	count, err := lkp.auditCount(day)
+	if err != nil {
+		return err
+	}
+	for number := 1; number <= count; number++ {
+		if err := lkp.sdk.KV.Delete(auditKey(day, number)); err != nil {
+			return errors.Wrap(err, "failed to delete audit event")
+		}
+	}
+	if err := lkp.sdk.KV.Delete(auditCountKeyPrefix + day); err != nil {
+		return errors.Wrap(err, "failed to delete audit event count")
+	}
+	return lkp.updateAuditDays(func(days []string) []string {
+		kept := []string{}
+		for _, known := range days {
+			if known != day {
+				kept = append(kept, known)
+			}
+		}
+		return kept
+	})
+}
+
+// parseAuditQuery reads the filters of GET /audit. The window defaults to the last 30 days.
+func parseAuditQuery(r *http.Request) (auditQuery, error) {
+	values := r.URL.Query()
+	query := auditQuery{
+		Until:     model.GetMillis(),
+		ActorID:   values.Get("user_id"),
+		Action:    values.Get("action"),
+		MeetingID: values.Get("meeting_id"),
+		ChannelID: values.Get("channel_id"),
+		Limit:     100,
+	}
+	var err error
+	if until := values.Get("until"); until != "" {
+		if query.Until, err = strconv.ParseInt(until, 10, 64); err != nil {
+			return query, newStatusError(http.StatusBadRequest, "until must be a time in milliseconds")
+		}
+	}
+	query.Since = query.Until - 30*24*time.Hour.Milliseconds()
+	if since := values.Get("since"); since != "" {
+		if query.Since, err = strconv.ParseInt(since, 10, 64); err != nil {
+			return query, newStatusError(http.StatusBadRequest, "since must be a time in milliseconds")
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > 1000 {
+			return query, newStatusError(http.StatusBadRequest, "limit must be between 1 and 1000")
+		}
+	}
+	if query.Since > query.Until {
+		return query, newStatusError(http.StatusBadRequest, "since must not be after until")
+	}
+	return query, nil
+}
+
+func (lkp *LiveKitPlugin) apiAudit(w http.ResponseWriter, r *http.Request) {
+	if !lkp.API.HasPermissionTo(requestUserID(r), model.PermissionManageSystem) {
+		writeError(w, newStatusError(http.StatusForbidden, "only system admins can read the audit log"))
+		return
+	}
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	events, err := lkp.queryAudit(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// executeAudit handles "/liveroom audit [days] [@user|action] [all]". It shows the events of
+// the meeting when run in its thread, of the current channel otherwise, or of every channel
+// with "all".
+func (lkp *LiveKitPlugin) executeAudit(args *model.CommandArgs, params []string) string {
+	if !lkp.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system admins can read the audit log."
+	}
+	days := 7
+	query := auditQuery{Until: model.GetMillis(), ChannelID: args.ChannelId, Limit: 20}
+	if args.RootId != "" {
+		if _, err := lkp.getMeeting(args.RootId); err == nil {
+			query.MeetingID = args.RootId
+		}
+	}
+	for _, param := range params {
+		number, err := strconv.Atoi(param)
+		switch {
+		case err == nil && number > 0:
+			days = number
+		case param == "all":
+			query.ChannelID = ""
+			query.MeetingID = ""
+		case strings.HasPrefix(param, "@"):
+			user, appErr := lkp.API.GetUserByUsername(strings.TrimPrefix(param, "@"))
+			if appErr != nil {
+				return fmt.Sprintf("User %s was not found.", param)
+			}
+			query.ActorID = user.Id
+		case strings.Contains(param, "."):
+			query.Action = param
+		default:
+			return "Usage: /liveroom audit [days] [@user|action] [all]"
+		}
+	}
+	query.Since = query.Until - int64(days)*24*time.Hour.Milliseconds()
+	events, err := lkp.queryAudit(query)
+	if err != nil {
+		return err.Error()
+	}
+	if len(events) == 0 {
+		return fmt.Sprintf("No audit events in the last %d days.", days)
+	}
+	lines := []string{fmt.Sprintf("Latest audit events of the last %d days:", days)}
+	for _, event := range events {
+		lines = append(lines, "* "+lkp.auditLine(event))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	pluginSDK "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	breakoutKeyPrefix       = "breakout_"
+	breakoutJobPrefix       = "breakout_end_"
+	breakoutReturnKeyPrefix = "breakout_return_"
+	maxBreakoutRooms        = 50
+	// breakoutReturnWindow is how long the participants of closed breakout rooms have to
+	// come back to the main room, even if it is locked.
+	breakoutReturnWindow = 2 * time.Minute
+)
+
+// breakoutRoom is a child room of a meeting together with the identities assigned to it.
+type breakoutRoom struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"`
+}
+
+// breakoutSession is kept in the KV store while the breakout rooms of a meeting are open,
+// so it outlives plugin restarts along with the job which closes the rooms.
+type breakoutSession struct {
+	MeetingID string         `json:"meeting_id"`
+	HostID    string         `json:"host_id"`
+	Rooms     []breakoutRoom `json:"rooms"`
+	EndsAt    int64          `json:"ends_at"`
+}
+
+// breakoutRequest describes the rooms requested by the host. Assignments map participant
+// identities to room numbers starting from 1, everyone else is distributed at random.
+type breakoutRequest struct {
+	PostID      string         `json:"post_id"`
+	Rooms       int            `json:"rooms"`
+	Minutes     int            `json:"minutes"`
+	Assignments map[string]int `json:"assignments,omitempty"`
+}
+
+// breakoutSignal tells a participant's client which room to move to.
+type breakoutSignal struct {
+	Type  string `json:"type"`
+	Room  string `json:"room"`
+	Token string `json:"token,omitempty"`
+}
+
+func (lkp *LiveKitPlugin) getBreakout(meetingID string) (*breakoutSession, error) {
+	var session *breakoutSession
+	err := lkp.sdk.KV.Get(breakoutKeyPrefix+meetingID, &session)
+	return session, err
+}
+
+func (session *breakoutSession) roomOf(userID string) string {
+	for _, room := range session.Rooms {
+		for _, identity := range room.Participants {
+			if identity == userID {
+				return room.Name
+			}
+		}
+	}
+	return ""
+}
+
+// returnsFromBreakout tells whether the user is assigned to a breakout room of the meeting,
+// or was when the breakout rooms closed a moment ago.
+func (lkp *LiveKitPlugin) returnsFromBreakout(meetingID, userID string) (bool, error) {
+	session, err := lkp.getBreakout(meetingID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read breakout state")
+	}
+	if session != nil && session.roomOf(userID) != "" {
+		return true, nil
+	}
+	returning := []string{}
+	if err := lkp.sdk.KV.Get(breakoutReturnKeyPrefix+meetingID, &returning); err != nil {
+		return false, errors.Wrap(err, "failed to read returning participants")
+	}
+	for _, identity := range returning {
+		if identity == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mainRoomToken brings a participant back from the breakout rooms. The lock let them in
+// already, but their seat in the main room is decided again as for a join.
+func (lkp *LiveKitPlugin) mainRoomToken(meeting *model.Post, user *model.User) (string, error) {
+	listener, err := lkp.checkCapacity(meeting, user.Id)
+	if err != nil {
+		return "", err
+	}
+	if listener {
+		return lkp.listenerToken(meeting.Id, user)
+	}
+	return lkp.roomToken(meeting.Id, user)
+}
+
+// startBreakout opens the requested number of child rooms, assigns the participants and hands
+// every connected participant a token for their room.
+func (lkp *LiveKitPlugin) startBreakout(hostID string, request breakoutRequest) (started *breakoutSession, err error) {
+	event := auditEvent{
+		Action:    auditBreakoutStart,
+		ActorID:   hostID,
+		MeetingID: request.PostID,
+		Details:   fmt.Sprintf("%d rooms for %d minutes", request.Rooms, request.Minutes),
+	}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err := lkp.getMeeting(request.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if !lkp.isHost(meeting, hostID) {
+		return nil, newStatusError(http.StatusForbidden, "only the host can open breakout rooms")
+	}
+	if request.Rooms < 1 || request.Minutes < 1 {
+		return nil, newStatusError(http.StatusBadRequest, "number of rooms and duration must be positive")
+	}
+	if request.Rooms > maxBreakoutRooms {
+		return nil, newStatusError(http.StatusBadRequest, "at most %d breakout rooms can be opened", maxBreakoutRooms)
+	}
+	existing, err := lkp.getBreakout(meeting.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read breakout state")
+	}
+	if existing != nil {
+		return nil, newStatusError(http.StatusConflict, "breakout rooms are already open")
+	}
+
+	participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: meeting.Id})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list participants")
+	}
+	sids := map[string]string{}
+	for _, participant := range participantList.Participants {
+		if participant.Identity != lkp.botUserID {
+			sids[participant.Identity] = participant.Sid
+		}
+	}
+	if request.Rooms > len(sids) {
+		return nil, newStatusError(http.StatusBadRequest, "%d participants can't fill %d breakout rooms", len(sids), request.Rooms)
+	}
+
+	session := &breakoutSession{
+		MeetingID: meeting.Id,
+		HostID:    hostID,
+		Rooms:     make([]breakoutRoom, request.Rooms),
+		EndsAt:    model.GetMillisForTime(time.Now().Add(time.Duration(request.Minutes) * time.Minute)),
+	}
+	for i := range session.Rooms {
+		session.Rooms[i].Name = fmt.Sprintf("%s-breakout-%d", meeting.Id, i+1)
+	}
+	unassigned := []string{}
+	for identity := range sids {
+		if n, found := request.Assignments[identity]; !found || n < 1 || n > request.Rooms {
+			unassigned = append(unassigned, identity)
+		}
+	}
+	for identity, n := range request.Assignments {
+		if n >= 1 && n <= request.Rooms {
+			session.Rooms[n-1].Participants = append(session.Rooms[n-1].Participants, identity)
+		}
+	}
+	shuffler := rand.New(rand.NewSource(time.Now().UnixNano()))
+	shuffler.Shuffle(len(unassigned), func(i, j int) { unassigned[i], unassigned[j] = unassigned[j], unassigned[i] })
+	for i, identity := range unassigned {
+		room := &session.Rooms[i%request.Rooms]
+		room.Participants = append(room.Participants, identity)
+	}
+
+	capacity, _ := meeting.GetProp("room_capacity").(float64)
+	for i, room := range session.Rooms {
+		_, err := lkp.master.CreateRoom(
+			context.Background(),
+			&livekit.CreateRoomRequest{
+				Name:            room.Name,
+				Metadata:        meeting.Id,
+				EmptyTimeout:    300,
+				MaxParticipants: uint32(capacity),
+			},
+		)
+		if err != nil {
+			lkp.deleteBreakoutRooms(session.Rooms[:i])
+			return nil, errors.Wrapf(err, "failed to create room %s", room.Name)
+		}
+	}
+
+	if _, err := lkp.sdk.KV.Set(breakoutKeyPrefix+meeting.Id, session); err != nil {
+		lkp.deleteBreakoutRooms(session.Rooms)
+		return nil, errors.Wrap(err, "failed to save breakout state")
+	}
+	if _, err := lkp.scheduler.ScheduleOnce(breakoutJobPrefix+meeting.Id, model.GetTimeForMillis(session.EndsAt)); err != nil {
+		lkp.deleteBreakoutRooms(session.Rooms)
+		if deleteErr := lkp.sdk.KV.Delete(breakoutKeyPrefix + meeting.Id); deleteErr != nil {
+			lkp.API.LogError("breakout state not deleted", "meeting", meeting.Id, "reason", deleteErr.Error())
+		}
+		return nil, errors.Wrap(err, "failed to schedule breakout end")
+	}
+	lkp.API.LogInfo("breakout started", "meeting", meeting.Id, "rooms", request.Rooms, "minutes", request.Minutes)
+
+	for _, room := range session.Rooms {
+		for _, identity := range room.Participants {
+			sid, connected := sids[identity]
+			if !connected {
+				continue
+			}
+			user, appErr := lkp.API.GetUser(identity)
+			if appErr != nil {
+				continue
+			}
+			jwt, err := lkp.roomToken(room.Name, user)
+			if err == nil {
+				err = lkp.signal(meeting.Id, breakoutSignal{Type: "breakout", Room: room.Name, Token: jwt}, sid)
+			}
+			if err != nil {
+				lkp.API.LogWarn("breakout token not delivered", "room", room.Name, "identity", identity, "reason", err.Error())
+			}
+		}
+	}
+
+	lines := []string{fmt.Sprintf("Breakout rooms are open for %d minutes:", request.Minutes)}
+	for i, room := range session.Rooms {
+		mentions := []string{}
+		for _, identity := range room.Participants {
+			if user, appErr := lkp.API.GetUser(identity); appErr == nil {
+				mentions = append(mentions, "@"+user.Username)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, strings.Join(mentions, ", ")))
+	}
+	lkp.threadReply(meeting, strings.Join(lines, "\n"))
+	return session, nil
+}
+
+// closeBreakout closes the breakout rooms on request of the host.
+func (lkp *LiveKitPlugin) closeBreakout(meetingID, userID string) (err error) {
+	defer func() { lkp.audit(auditEvent{Action: auditBreakoutEnd, ActorID: userID, MeetingID: meetingID}, err) }()
+	meeting, err := lkp.getMeeting(meetingID)
+	if err != nil {
+		return err
+	}
+	if !lkp.isHost(meeting, userID) {
+		return newStatusError(http.StatusForbidden, "only the host can close breakout rooms")
+	}
+	return lkp.stopBreakout(meeting.Id)
+}
+
+// stopBreakout closes the breakout rooms before their timer runs out.
+func (lkp *LiveKitPlugin) stopBreakout(meetingID string) error {
+	lkp.scheduler.Cancel(breakoutJobPrefix + meetingID)
+	return lkp.endBreakout(meetingID)
+}
+
+// endBreakout sends everyone back to the main room and closes the child rooms.
+// It is also the callback of the scheduled job, so it must not cancel the job itself.
+func (lkp *LiveKitPlugin) endBreakout(meetingID string) error {
+	session, err := lkp.getBreakout(meetingID)
+	if err != nil {
+		return errors.Wrap(err, "failed to read breakout state")
+	}
+	if session == nil {
+		return newStatusError(http.StatusNotFound, "no breakout rooms are open")
+	}
+	for _, room := range session.Rooms {
+		if err := lkp.signal(room.Name, breakoutSignal{Type: "breakout_end", Room: meetingID}); err != nil {
+			lkp.API.LogWarn("breakout end not delivered", "room", room.Name, "reason", err.Error())
+		}
+	}
+	returning := []string{}
+	for _, room := range session.Rooms {
+		returning = append(returning, room.Participants...)
+	}
+	if _, err := lkp.sdk.KV.Set(breakoutReturnKeyPrefix+meetingID, returning, pluginSDK.SetExpiry(breakoutReturnWindow)); err != nil {
+		lkp.API.LogWarn("returning participants not saved", "meeting", meetingID, "reason", err.Error())
+	}
+	lkp.deleteBreakoutRooms(session.Rooms)
+	if err := lkp.sdk.KV.Delete(breakoutKeyPrefix + meetingID); err != nil {
+		return errors.Wrap(err, "failed to clear breakout state")
+	}
+	lkp.API.LogInfo("breakout ended", "meeting", meetingID)
+	if meeting, err := lkp.getMeeting(meetingID); err == nil {
+		lkp.threadReply(meeting, "Breakout rooms are closed, everyone is back in the main room.")
+	}
+	return nil
+}
+
+func (lkp *LiveKitPlugin) deleteBreakoutRooms(rooms []breakoutRoom) {
+	for _, room := range rooms {
+		if _, err := lkp.master.DeleteRoom(context.Background(), &livekit.DeleteRoomRequest{Room: room.Name}); err != nil {
+			lkp.API.LogWarn("breakout room not deleted", "room", room.Name, "reason", err.Error())
+		}
+	}
+}
+
+// breakoutToken lets an assigned participant (re)join their breakout room, it also serves
+// as the refresh call while the participant stays there.
+func (lkp *LiveKitPlugin) breakoutToken(meetingID, userID string) (string, error) {
+	_, user, err := lkp.authorizeMeeting(meetingID, userID)
+	if err != nil {
+		return "", err
+	}
+	session, err := lkp.getBreakout(meetingID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read breakout state")
+	}
+	if session == nil {
+		return "", newStatusError(http.StatusNotFound, "no breakout rooms are open")
+	}
+	roomName := session.roomOf(userID)
+	if roomName == "" {
+		return "", newStatusError(http.StatusNotFound, "you are not assigned to a breakout room")
+	}
+	return lkp.roomToken(roomName, user)
+}
+
+// signal sends a JSON data packet to the room, optionally to the given participants only.
+func (lkp *LiveKitPlugin) signal(roomName string, message interface{}, sids ...string) error {
+	packet, err := json.Marshal(message)
+	if err == nil {
+		_, err = lkp.master.SendData(
+			context.Background(),
+			&livekit.SendDataRequest{Room: roomName, Data: packet, Kind: livekit.DataPacket_RELIABLE, DestinationSids: sids},
+		)
+	}
+	return err
+}
+
+// threadReply posts a bot message into the meeting thread.
+func (lkp *LiveKitPlugin) threadReply(meeting *model.Post, message string) *model.Post {
+	reply, appErr := lkp.API.CreatePost(&model.Post{
+		UserId:    lkp.botUserID,
+		ChannelId: meeting.ChannelId,
+		RootId:    meeting.Id,
+		Message:   message,
+	})
+	if appErr != nil {
+		lkp.API.LogError("thread reply failed", "meeting", meeting.Id, "reason", appErr.Error())
+	}
+	return reply
+}
+
+// executeBreakout handles "/liveroom breakout N [minutes]" and "/liveroom breakout end",
+// both of which are expected to be run from the meeting thread.
+func (lkp *LiveKitPlugin) executeBreakout(args *model.CommandArgs, params []string) string {
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if len(params) == 1 && params[0] == "end" {
+		if err := lkp.closeBreakout(args.RootId, args.UserId); err != nil {
+			return err.Error()
+		}
+		return "Breakout rooms closed."
+	}
+	request := breakoutRequest{PostID: args.RootId, Minutes: 15}
+	if len(params) == 0 || len(params) > 2 {
+		return "Usage: /liveroom breakout N [minutes] or /liveroom breakout end"
+	}
+	var err error
+	request.Rooms, err = strconv.Atoi(params[0])
+	if err == nil && len(params) == 2 {
+		request.Minutes, err = strconv.Atoi(params[1])
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if _, err := lkp.startBreakout(args.UserId, request); err != nil {
+		return fmt.Sprintf("Breakout failed: %s", err.Error())
+	}
+	return fmt.Sprintf("Opened %d breakout rooms for %d minutes.", request.Rooms, request.Minutes)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	kitSDK "github.com/livekit/server-sdk-go"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+// chatMessage is the data packet exchanged between a meeting thread and its LiveKit room.
+// Participants publish it with type "chat", the plugin forwards thread replies the same way.
+type chatMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// MessageHasBeenPosted forwards replies in a meeting thread to the participants of its room.
+func (lkp *LiveKitPlugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.RootId == "" || post.UserId == lkp.botUserID || post.Type != model.PostTypeDefault {
+		return
+	}
+	rootPost, appErr := lkp.API.GetPost(post.RootId)
+	if appErr != nil || rootPost.Type != "custom_livekit" {
+		return
+	}
+	author, appErr := lkp.API.GetUser(post.UserId)
+	if appErr != nil {
+		lkp.API.LogError("thread reply author not found", "user_id", post.UserId, "reason", appErr.Error())
+		return
+	}
+	packet, err := json.Marshal(chatMessage{
+		Type:      "chat",
+		ID:        post.Id,
+		Identity:  author.Id,
+		Name:      author.GetDisplayName(model.ShowFullName),
+		Message:   post.Message,
+		Timestamp: post.CreateAt,
+	})
+	if err == nil {
+		_, err = lkp.master.SendData(
+			context.Background(),
+			&livekit.SendDataRequest{Room: rootPost.Id, Data: packet, Kind: livekit.DataPacket_RELIABLE},
+		)
+	}
+	if err != nil {
+		lkp.API.LogWarn("thread reply was not forwarded", "post_id", post.Id, "room", rootPost.Id, "reason", err.Error())
+	}
+}
+
+// bridgeRoom connects the bot to the room as a hidden participant, so chat messages published
+// by the participants can be copied into the meeting thread. It is safe to call repeatedly.
+// The bot joins as a recorder, which LiveKit leaves out of MaxParticipants and EmptyTimeout.
+func (lkp *LiveKitPlugin) bridgeRoom(post *model.Post) {
+	// The entry is reserved while the bot connects, so the lock is not held during the dial.
+	lkp.bridgeLock.Lock()
+	if _, found := lkp.bridges[post.Id]; found {
+		lkp.bridgeLock.Unlock()
+		return
+	}
+	lkp.bridges[post.Id] = nil
+	lkp.bridgeLock.Unlock()
+
+	accessToken := auth.NewAccessToken(lkp.configuration.ApiKey, lkp.configuration.ApiValue)
+	grant := &auth.VideoGrant{RoomJoin: true, Room: post.Id, Hidden: true, Recorder: true}
+	grant.SetCanPublish(false)
+	grant.SetCanSubscribe(false)
+	accessToken.AddGrant(grant).SetValidFor(time.Hour * 12).SetIdentity(lkp.botUserID).SetName("Broadcasting")
+	jwt, err := accessToken.ToJWT()
+	if err != nil {
+		lkp.API.LogError("bridge token failed", "room", post.Id, "reason", err.Error())
+		lkp.unreserveBridge(post.Id)
+		return
+	}
+
+	room := kitSDK.CreateRoom()
+	room.Callback.OnDataReceived = func(data []byte, rp *kitSDK.RemoteParticipant) {
+		lkp.relayChatMessage(post, data, rp.Identity())
+	}
+	room.Callback.OnDisconnected = func() {
+		lkp.API.LogInfo("bridge disconnected", "room", post.Id)
+		lkp.bridgeLock.Lock()
+		if lkp.bridges[post.Id] == room {
+			delete(lkp.bridges, post.Id)
+		}
+		lkp.bridgeLock.Unlock()
+	}
+	serverURL := fmt.Sprintf("wss://%s:%d", lkp.configuration.Host, lkp.configuration.Port)
+	if err = room.JoinWithToken(serverURL, jwt, kitSDK.WithAutoSubscribe(false)); err != nil {
+		lkp.API.LogError("bridge connection failed", "room", post.Id, "reason", err.Error())
+		lkp.unreserveBridge(post.Id)
+		return
+	}
+
+	// The room may have been released or the plugin deactivated during the dial.
+	lkp.bridgeLock.Lock()
+	current, reserved := lkp.bridges[post.Id]
+	reserved = reserved && current == nil
+	if reserved {
+		lkp.bridges[post.Id] = room
+	}
+	lkp.bridgeLock.Unlock()
+	if !reserved {
+		room.Disconnect()
+		return
+	}
+	lkp.API.LogInfo("bridge connected", "room", post.Id)
+}
+
+// unreserveBridge drops the entry reserved by a connection which failed.
+func (lkp *LiveKitPlugin) unreserveBridge(roomName string) {
+	lkp.bridgeLock.Lock()
+	if current, found := lkp.bridges[roomName]; found && current == nil {
+		delete(lkp.bridges, roomName)
+	}
+	lkp.bridgeLock.Unlock()
+}
+
+// relayChatMessage posts a chat data packet into the meeting thread on behalf of its sender.
+func (lkp *LiveKitPlugin) relayChatMessage(meeting *model.Post, data []byte, identity string) {
+	var message chatMessage
+	if err := json.Unmarshal(data, &message); err != nil || message.Type != "chat" || message.Message == "" {
+		return
+	}
+	sender, appErr := lkp.API.GetUser(identity)
+	if appErr != nil {
+		lkp.API.LogWarn("chat message from unknown identity", "room", meeting.Id, "identity", identity)
+		return
+	}
+	reply := &model.Post{
+		UserId:    lkp.botUserID,
+		ChannelId: meeting.ChannelId,
+		RootId:    meeting.Id,
+		Message:   message.Message,
+		Props: map[string]interface{}{
+			"from_webhook":      "true",
+			"override_username": sender.GetDisplayName(model.ShowFullName),
+			"livekit_identity":  sender.Id,
+		},
+	}
+	if _, appErr := lkp.API.CreatePost(reply); appErr != nil {
+		lkp.API.LogError("chat message was not posted", "room", meeting.Id, "reason", appErr.Error())
+	}
+}
+
+// releaseBridge disconnects the bot once the last participant has left the room, so the
+// room can close.
+func (lkp *LiveKitPlugin) releaseBridge(room *livekit.Room) {
+	if room == nil {
+		return
+	}
+	participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: room.Name})
+	if err != nil {
+		return
+	}
+	for _, participant := range participantList.Participants {
+		if participant.Identity != lkp.botUserID {
+			return
+		}
+	}
+	lkp.bridgeLock.Lock()
+	bridge, found := lkp.bridges[room.Name]
+	delete(lkp.bridges, room.Name)
+	lkp.bridgeLock.Unlock()
+	if found && bridge != nil {
+		bridge.Disconnect()
+	}
+}
+
+// closeBridges disconnects the bot from every room it is listening to.
+func (lkp *LiveKitPlugin) closeBridges() {
+	lkp.bridgeLock.Lock()
+	rooms := lkp.bridges
+	lkp.bridges = map[string]*kitSDK.Room{}
+	lkp.bridgeLock.Unlock()
+	for _, room := range rooms {
+		if room != nil {
+			room.Disconnect()
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	scheduleKeyPrefix      = "scheduled_"
+	calendarTokenKeyPrefix = "calendar_token_"
+	calendarFeedKeyPrefix  = "calendar_feed_"
+	calendarMeetingsKey    = "calendar_meetings"
+	icsTimeLayout          = "20060102T150405Z"
+)
+
+// scheduledMeeting indexes the meetings which have a start time, so the calendar feeds
+// don't need to search the posts.
+type scheduledMeeting struct {
+	PostID    string `json:"post_id"`
+	ChannelID string `json:"channel_id"`
+	HostID    string `json:"host_id"`
+	Topic     string `json:"topic"`
+	StartAt   int64  `json:"start_at"`
+	Duration  int    `json:"duration"`
+}
+
+func (lkp *LiveKitPlugin) siteURL() string {
+	return strings.TrimSuffix(*lkp.API.GetConfig().ServiceSettings.SiteURL, "/")
+}
+
+// pluginURL is the public address of the plugin's HTTP routes.
+func (lkp *LiveKitPlugin) pluginURL() string {
+	return lkp.siteURL() + "/plugins/" + pluginID
+}
+
+// joinLink opens the meeting in the browser, see serveMeetingPage.
+func (lkp *LiveKitPlugin) joinLink(meetingID string) string {
+	return fmt.Sprintf("%s/meet/%s", lkp.pluginURL(), meetingID)
+}
+
+// scheduleMeeting indexes the newly created meeting and posts a calendar invite to its thread.
+func (lkp *LiveKitPlugin) scheduleMeeting(meeting *model.Post, options meetingOptions) error {
+	scheduled := scheduledMeeting{
+		PostID:    meeting.Id,
+		ChannelID: meeting.ChannelId,
+		Topic:     meeting.Message,
+		StartAt:   options.StartAt,
+		Duration:  options.Duration,
+	}
+	scheduled.HostID, _ = meeting.GetProp("room_host").(string)
+	if _, err := lkp.sdk.KV.Set(scheduleKeyPrefix+meeting.Id, scheduled); err != nil {
+		return errors.Wrap(err, "failed to save meeting schedule")
+	}
+	if _, err := lkp.getCalendarMeetings(); err != nil {
+		return err
+	}
+	err := lkp.updateCalendarMeetings(func(meetingIDs []string) []string {
+		for _, meetingID := range meetingIDs {
+			if meetingID == meeting.Id {
+				return meetingIDs
+			}
+		}
+		return append(meetingIDs, meeting.Id)
+	})
+	if err != nil {
+		return err
+	}
+	invite := lkp.icsCalendar([]scheduledMeeting{scheduled})
+	fileInfo, appErr := lkp.API.UploadFile([]byte(invite), meeting.ChannelId, "invite.ics")
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to upload invite")
+	}
+	start := model.GetTimeForMillis(scheduled.StartAt).UTC()
+	_, appErr = lkp.API.CreatePost(&model.Post{
+		UserId:    lkp.botUserID,
+		ChannelId: meeting.ChannelId,
+		RootId:    meeting.Id,
+		Message:   fmt.Sprintf("Meeting is scheduled for %s (%d minutes). Add it to your calendar with the attached invite.", start.Format(time.RFC1123), scheduled.Duration),
+		FileIds:   model.StringArray{fileInfo.Id},
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to post invite")
+	}
+	lkp.API.LogInfo("meeting scheduled", "meeting", meeting.Id, "start", start.String())
+	return nil
+}
+
+// icsCalendar renders the meetings as an iCalendar document.
+func (lkp *LiveKitPlugin) icsCalendar(meetings []scheduledMeeting) string {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ITCDEK//Mattermost LiveKit plugin//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:LiveKit meetings",
+	}
+	now := time.Now().UTC().Format(icsTimeLayout)
+	for _, meeting := range meetings {
+		start := model.GetTimeForMillis(meeting.StartAt).UTC()
+		end := start.Add(time.Duration(meeting.Duration) * time.Minute)
+		link := lkp.joinLink(meeting.PostID)
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%s@%s", meeting.PostID, pluginID),
+			"DTSTAMP:"+now,
+			"DTSTART:"+start.Format(icsTimeLayout),
+			"DTEND:"+end.Format(icsTimeLayout),
+			"SUMMARY:"+icsEscape(meeting.Topic),
+			"DESCRIPTION:"+icsEscape("Join the meeting: "+link),
+			"URL:"+link,
+			"LOCATION:"+icsEscape(link),
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+	folded := make([]string, 0, len(lines))
+	for _, line := range lines {
+		folded = append(folded, icsFold(line))
+	}
+	return strings.Join(folded, "\r\n") + "\r\n"
+}
+
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// icsFold splits content lines longer than 75 octets as RFC 5545 requires,
+// taking care not to cut multibyte characters.
+func icsFold(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	return folded.String()
+}
+
+// calendarFeedURL returns the secret subscription address of the user's feed, creating
+// its token on first use. With reset set, the old address stops working.
+func (lkp *LiveKitPlugin) calendarFeedURL(userID string, reset bool) (string, error) {
+	var token string
+	if err := lkp.sdk.KV.Get(calendarTokenKeyPrefix+userID, &token); err != nil {
+		return "", errors.Wrap(err, "failed to read calendar token")
+	}
+	if token != "" && reset {
+		if err := lkp.sdk.KV.Delete(calendarFeedKeyPrefix + token); err != nil {
+			return "", errors.Wrap(err, "failed to revoke calendar token")
+		}
+		token = ""
+	}
+	if token == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return "", err
+		}
+		token = hex.EncodeToString(secret)
+		if _, err := lkp.sdk.KV.Set(calendarFeedKeyPrefix+token, userID); err != nil {
+			return "", errors.Wrap(err, "failed to save calendar token")
+		}
+		if _, err := lkp.sdk.KV.Set(calendarTokenKeyPrefix+userID, token); err != nil {
+			return "", errors.Wrap(err, "failed to save calendar token")
+		}
+	}
+	return fmt.Sprintf("%s/calendar/feed?token=%s", lkp.pluginURL(), url.QueryEscape(token)), nil
+}
+
+// getCalendarMeetings returns the ids of the scheduled meetings. The list is built from the
+// KV store the first time, for the meetings scheduled before it existed.
+func (lkp *LiveKitPlugin) getCalendarMeetings() ([]string, error) {
+	var meetingIDs []string
+	if err := lkp.sdk.KV.Get(calendarMeetingsKey, &meetingIDs); err != nil {
+		return nil, errors.Wrap(err, "failed to read scheduled meetings")
+	}
+	if meetingIDs != nil {
+		return meetingIDs, nil
+	}
+	keys, err := lkp.listKeys(scheduleKeyPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list scheduled meetings")
+	}
+	err = lkp.updateCalendarMeetings(func(meetingIDs []string) []string {
+		known := map[string]bool{}
+		for _, meetingID := range meetingIDs {
+			known[meetingID] = true
+		}
+		for _, key := range keys {
+			if meetingID := strings.TrimPrefix(key, scheduleKeyPrefix); !known[meetingID] {
+				meetingIDs = append(meetingIDs, meetingID)
+			}
+		}
+		return meetingIDs
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lkp.getCalendarMeetings()
+}
+
+func (lkp *LiveKitPlugin) updateCalendarMeetings(update func([]string) []string) error {
+	err := lkp.sdk.KV.SetAtomicWithRetries(calendarMeetingsKey, func(oldValue []byte) (interface{}, error) {
+		meetingIDs := []string{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &meetingIDs); err != nil {
+				return nil, err
+			}
+		}
+		return update(meetingIDs), nil
+	})
+	return errors.Wrap(err, "failed to save scheduled meetings")
+}
+
+// unscheduleMeeting drops an ended or deleted meeting from the calendar feeds.
+func (lkp *LiveKitPlugin) unscheduleMeeting(meetingID string) {
+	if err := lkp.sdk.KV.Delete(scheduleKeyPrefix + meetingID); err != nil {
+		lkp.API.LogWarn("scheduled meeting not unindexed", "meeting", meetingID, "reason", err.Error())
+		return
+	}
+	err := lkp.updateCalendarMeetings(func(meetingIDs []string) []string {
+		kept := []string{}
+		for _, scheduled := range meetingIDs {
+			if scheduled != meetingID {
+				kept = append(kept, scheduled)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		lkp.API.LogWarn("scheduled meeting not unindexed", "meeting", meetingID, "reason", err.Error())
+	}
+}
+
+// userCalendar collects the scheduled meetings of every channel the user belongs to. Meetings
+// whose post has ended or is gone, such as those deleted in Mattermost itself, are unindexed.
+func (lkp *LiveKitPlugin) userCalendar(userID string) ([]scheduledMeeting, error) {
+	meetingIDs, err := lkp.getCalendarMeetings()
+	if err != nil {
+		return nil, err
+	}
+	meetings := []scheduledMeeting{}
+	membership := map[string]bool{}
+	for _, meetingID := range meetingIDs {
+		var meeting *scheduledMeeting
+		if err := lkp.sdk.KV.Get(scheduleKeyPrefix+meetingID, &meeting); err != nil {
+			continue
+		}
+		if meeting == nil {
+			lkp.unscheduleMeeting(meetingID)
+			continue
+		}
+		member, checked := membership[meeting.ChannelID]
+		if !checked {
+			_, appErr := lkp.API.GetChannelMember(meeting.ChannelID, userID)
+			member = appErr == nil
+			membership[meeting.ChannelID] = member
+		}
+		if !member {
+			continue
+		}
+		post, appErr := lkp.API.GetPost(meeting.PostID)
+		if (appErr != nil && appErr.StatusCode == http.StatusNotFound) || (appErr == nil && meetingEnded(post)) {
+			lkp.unscheduleMeeting(meeting.PostID)
+			continue
+		}
+		meetings = append(meetings, *meeting)
+	}
+	return meetings, nil
+}
+
+// serveCalendarFeed answers calendar applications, which only know the secret token.
+func (lkp *LiveKitPlugin) serveCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	var userID string
+	token := r.URL.Query().Get("token")
+	if token != "" {
+		if err := lkp.sdk.KV.Get(calendarFeedKeyPrefix+token, &userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if userID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	meetings, err := lkp.userCalendar(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCalendar(w, "livekit.ics", lkp.icsCalendar(meetings))
+}
+
+// serveMeetingCalendar sends the .ics file of a single meeting to a channel member.
+func (lkp *LiveKitPlugin) serveMeetingCalendar(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	if _, _, err := lkp.authorizeMeeting(postID, requestUserID(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+	var meeting *scheduledMeeting
+	if err := lkp.sdk.KV.Get(scheduleKeyPrefix+postID, &meeting); err != nil {
+		writeError(w, err)
+		return
+	}
+	if meeting == nil {
+		writeError(w, newStatusError(http.StatusNotFound, "meeting is not scheduled"))
+		return
+	}
+	writeCalendar(w, "meeting.ics", lkp.icsCalendar([]scheduledMeeting{*meeting}))
+}
+
+func writeCalendar(w http.ResponseWriter, filename, calendar string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write([]byte(calendar))
+}
+
+// executeSchedule handles `/liveroom schedule "topic" YYYY-MM-DD HH:MM [minutes]`, the time
+// being read in the user's own timezone.
+func (lkp *LiveKitPlugin) executeSchedule(args *model.CommandArgs) string {
+	if err := lkp.canCreateMeeting(args.ChannelId, args.UserId); err != nil {
+		return err.Error()
+	}
+	usage := `Usage: /liveroom schedule "topic" YYYY-MM-DD HH:MM [minutes]`
+	splitted := strings.Split(args.Command, "\"")
+	if len(splitted) != 3 {
+		return usage
+	}
+	params := strings.Fields(splitted[2])
+	if len(params) < 2 || len(params) > 3 {
+		return usage
+	}
+	user, appErr := lkp.API.GetUser(args.UserId)
+	if appErr != nil {
+		return appErr.Error()
+	}
+	location, err := time.LoadLocation(user.GetPreferredTimezone())
+	if err != nil {
+		location = time.UTC
+	}
+	start, err := time.ParseInLocation("2006-01-02 15:04", params[0]+" "+params[1], location)
+	if err != nil {
+		return usage
+	}
+	options := meetingOptions{StartAt: model.GetMillisForTime(start), Duration: 60}
+	if len(params) == 3 {
+		if _, err := fmt.Sscanf(params[2], "%d", &options.Duration); err != nil || options.Duration < 1 {
+			return usage
+		}
+	}
+	if _, appErr := lkp.createPost(args.ChannelId, args.UserId, splitted[1], 0, options); appErr != nil {
+		return fmt.Sprintf("Room creation failed: %s", appErr.DetailedError)
+	}
+	return fmt.Sprintf("Meeting scheduled for %s.", start.Format("Mon, 02 Jan 2006 15:04 MST"))
+}
+
+// executeCalendar handles "/liveroom calendar [reset]".
+func (lkp *LiveKitPlugin) executeCalendar(args *model.CommandArgs, params []string) string {
+	feedURL, err := lkp.calendarFeedURL(args.UserId, len(params) == 1 && params[0] == "reset")
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Subscribe to this address in your calendar application to see the meetings of your channels. Keep it secret, anyone who knows it can read your schedule:\n%s", feedURL)
+}
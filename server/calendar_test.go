@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestICSEscape(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`Sprint review\, Q4\; part 1\nsee C:\\notes`, icsEscape("Sprint review, Q4; part 1\nsee C:\\notes"))
+}
+
+func TestICSFold(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("SUMMARY:short", icsFold("SUMMARY:short"))
+
+	line := "DESCRIPTION:" + strings.Repeat("Встреча ", 20)
+	folded := icsFold(line)
+	for _, part := range strings.Split(folded, "\r\n") {
+		assert.LessOrEqual(len(part), 75)
+	}
+	assert.Equal(line, strings.ReplaceAll(folded, "\r\n ", ""))
+}
+
+func TestUserCalendarDropsEndedMeetings(t *testing.T) {
+	lkp, api, _ := newTestPlugin(t)
+	ended := testMeeting(0)
+	ended.Id = "endedid"
+	ended.AddProp("room_status", "ended")
+	expectMember(api, testMeeting(0))
+	api.On("GetPost", "endedid").Return(ended, nil)
+	api.On("GetPost", "deletedid").Return(nil, model.NewAppError("GetPost", "not_found", nil, "", http.StatusNotFound))
+	for i := 0; i < 2*kvListPageSize; i++ {
+		api.kv[fmt.Sprintf("history_%04d", i)] = []byte("{}")
+	}
+	for _, postID := range []string{testMeetingID, "endedid", "deletedid"} {
+		_, err := lkp.sdk.KV.Set(scheduleKeyPrefix+postID, scheduledMeeting{PostID: postID, ChannelID: testChannelID})
+		require.NoError(t, err)
+	}
+
+	meetings, err := lkp.userCalendar(testUserID)
+
+	require.NoError(t, err)
+	require.Len(t, meetings, 1)
+	assert.Equal(t, testMeetingID, meetings[0].PostID)
+	keys, err := lkp.listKeys(scheduleKeyPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{scheduleKeyPrefix + testMeetingID}, keys)
+	meetingIDs, err := lkp.getCalendarMeetings()
+	require.NoError(t, err)
+	assert.Equal(t, []string{testMeetingID}, meetingIDs)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	queueKeyPrefix = "queue_"
+	overflowQueue  = "queue"
+	overflowListen = "listen"
+)
+
+// validOverflow tells whether the mode is known, the empty mode turns late joiners away.
+func validOverflow(mode string) bool {
+	return mode == "" || mode == overflowQueue || mode == overflowListen
+}
+
+func overflowOf(meeting *model.Post) string {
+	mode, _ := meeting.GetProp("room_overflow").(string)
+	return mode
+}
+
+// roomLimit is the MaxParticipants of the LiveKit room. Listeners need room beyond the
+// capacity, so the plugin counts the seats on its own in that mode.
+func roomLimit(meeting *model.Post) uint32 {
+	if overflowOf(meeting) == overflowListen {
+		return 0
+	}
+	return uint32(numberProp(meeting, "room_capacity"))
+}
+
+// seatsTaken counts the participants who may publish, leaving out the bot and listeners.
+func (lkp *LiveKitPlugin) seatsTaken(roomName string) int {
+	participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: roomName})
+	if err != nil {
+		return 0
+	}
+	taken := 0
+	for _, participant := range participantList.Participants {
+		if participant.Identity == lkp.botUserID || isListener(participant) {
+			continue
+		}
+		taken++
+	}
+	return taken
+}
+
+func isListener(participant *livekit.ParticipantInfo) bool {
+	return participant.Permission != nil && !participant.Permission.CanPublish
+}
+
+// checkCapacity tells whether the user gets a seat in the meeting. When the meeting is full,
+// the overflow mode decides between a listen-only token, the queue or a refusal.
+func (lkp *LiveKitPlugin) checkCapacity(meeting *model.Post, userID string) (listener bool, err error) {
+	capacity := int(numberProp(meeting, "room_capacity"))
+	if capacity == 0 || lkp.inRoom(meeting.Id, userID) || lkp.seatsTaken(meeting.Id) < capacity {
+		return false, nil
+	}
+	switch overflowOf(meeting) {
+	case overflowListen:
+		return true, nil
+	case overflowQueue:
+		if err := lkp.enqueue(meeting.Id, userID, true); err != nil {
+			return false, err
+		}
+		return false, newStatusError(http.StatusConflict, "meeting is full, you will get a message when a seat frees up")
+	}
+	return false, newStatusError(http.StatusConflict, "meeting is full")
+}
+
+// listenerToken lets the user follow the meeting without publishing anything but chat.
+func (lkp *LiveKitPlugin) listenerToken(roomName string, user *model.User) (string, error) {
+	canPublish := false
+	accessToken := auth.NewAccessToken(lkp.configuration.ApiKey, lkp.configuration.ApiValue)
+	grant := &auth.VideoGrant{RoomJoin: true, Room: roomName, CanPublish: &canPublish}
+	userName := user.GetDisplayName("full_name")
+	accessToken.AddGrant(grant).SetValidFor(tokenLifetime).SetIdentity(user.Id).SetName(userName)
+	return accessToken.ToJWT()
+}
+
+// listensTo tells whether the connected user joined the room as a listener.
+func (lkp *LiveKitPlugin) listensTo(roomName, userID string) bool {
+	participant, err := lkp.master.GetParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: roomName, Identity: userID})
+	return err == nil && isListener(participant)
+}
+
+func (lkp *LiveKitPlugin) getQueue(meetingID string) ([]string, error) {
+	queue := []string{}
+	err := lkp.sdk.KV.Get(queueKeyPrefix+meetingID, &queue)
+	return queue, err
+}
+
+// enqueue adds the user at the end of the waiting queue of the meeting, or takes them out.
+func (lkp *LiveKitPlugin) enqueue(meetingID, userID string, waiting bool) error {
+	return lkp.sdk.KV.SetAtomicWithRetries(queueKeyPrefix+meetingID, func(oldValue []byte) (interface{}, error) {
+		queue := []string{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &queue); err != nil {
+				return nil, err
+			}
+		}
+		updated := []string{}
+		for _, queued := range queue {
+			if queued != userID {
+				updated = append(updated, queued)
+			}
+		}
+		if waiting {
+			updated = append(updated, userID)
+		}
+		return updated, nil
+	})
+}
+
+// offerSeat tells the first user in the queue that a seat is free. The seat is not held for
+// them, whoever joins first takes it.
+func (lkp *LiveKitPlugin) offerSeat(room *livekit.Room) {
+	if room == nil || meetingOfRoom(room.Name) != room.Name {
+		return
+	}
+	queue, err := lkp.getQueue(room.Name)
+	if err != nil || len(queue) == 0 {
+		return
+	}
+	meeting, err := lkp.getMeeting(room.Name)
+	if err != nil || meetingEnded(meeting) {
+		return
+	}
+	capacity := int(numberProp(meeting, "room_capacity"))
+	if capacity > 0 && lkp.seatsTaken(room.Name) >= capacity {
+		return
+	}
+	userID := queue[0]
+	if err := lkp.enqueue(meeting.Id, userID, false); err != nil {
+		lkp.API.LogError("meeting queue not updated", "meeting", meeting.Id, "reason", err.Error())
+		return
+	}
+	message := fmt.Sprintf("A seat is free in the meeting **%s**, join before someone else does:\n[Join the meeting](%s)", meeting.Message, lkp.joinLink(meeting.Id))
+	if err := lkp.directMessage(userID, message); err != nil {
+		lkp.API.LogWarn("seat offer not sent", "user_id", userID, "reason", err.Error())
+		return
+	}
+	lkp.API.LogInfo("seat offered", "meeting", meeting.Id, "user_id", userID)
+}
+
+// leaveQueue forgets the participant who made it into the room.
+func (lkp *LiveKitPlugin) leaveQueue(room *livekit.Room, participant *livekit.ParticipantInfo) {
+	if room == nil || participant == nil || meetingOfRoom(room.Name) != room.Name {
+		return
+	}
+	queue, err := lkp.getQueue(room.Name)
+	if err != nil {
+		return
+	}
+	waiting := false
+	for _, queued := range queue {
+		waiting = waiting || queued == participant.Identity
+	}
+	if !waiting {
+		return
+	}
+	if err := lkp.enqueue(room.Name, participant.Identity, false); err != nil {
+		lkp.API.LogWarn("meeting queue not updated", "meeting", room.Name, "reason", err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	pluginSDK "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// codeKeyPrefix maps a meeting code to the id of its post. A code is reserved with an empty
+// value before the post exists, so two meetings never share it.
+const codeKeyPrefix = "code_"
+
+const codeLetters = "abcdefghijklmnopqrstuvwxyz"
+
+// codePattern accepts codes with or without the dashes, as people type them.
+var codePattern = regexp.MustCompile(`^([a-z]{3})-?([a-z]{4})-?([a-z]{3})$`)
+
+func codeOf(meeting *model.Post) string {
+	code, _ := meeting.GetProp("room_code").(string)
+	return code
+}
+
+// normalizeCode returns the code in its kqz-mtwp-rda form, or "" when it isn't one.
+func normalizeCode(code string) string {
+	parts := codePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(code)))
+	if parts == nil {
+		return ""
+	}
+	return strings.Join(parts[1:], "-")
+}
+
+func randomCode() (string, error) {
+	letters := make([]byte, 10)
+	for i := range letters {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeLetters))))
+		if err != nil {
+			return "", err
+		}
+		letters[i] = codeLetters[n.Int64()]
+	}
+	return fmt.Sprintf("%s-%s-%s", letters[:3], letters[3:7], letters[7:]), nil
+}
+
+// reserveCode picks a code which no other meeting has.
+func (lkp *LiveKitPlugin) reserveCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomCode()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to generate meeting code")
+		}
+		saved, err := lkp.sdk.KV.Set(codeKeyPrefix+code, "", pluginSDK.SetAtomic(nil))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to reserve meeting code")
+		}
+		if saved {
+			return code, nil
+		}
+	}
+	return "", errors.New("no free meeting code found")
+}
+
+// linkCode points the reserved code to the meeting post, or frees it when the post wasn't
+// created.
+func (lkp *LiveKitPlugin) linkCode(code, meetingID string) {
+	var err error
+	if meetingID == "" {
+		err = lkp.sdk.KV.Delete(codeKeyPrefix + code)
+	} else {
+		_, err = lkp.sdk.KV.Set(codeKeyPrefix+code, meetingID)
+	}
+	if err != nil {
+		lkp.API.LogError("meeting code not saved", "code", code, "meeting", meetingID, "reason", err.Error())
+	}
+}
+
+// meetingIDOf resolves a meeting code, any other value is taken for a post id.
+func (lkp *LiveKitPlugin) meetingIDOf(codeOrID string) (string, error) {
+	code := normalizeCode(codeOrID)
+	if code == "" {
+		return codeOrID, nil
+	}
+	var meetingID string
+	if err := lkp.sdk.KV.Get(codeKeyPrefix+code, &meetingID); err != nil {
+		return "", errors.Wrap(err, "failed to read meeting code")
+	}
+	if meetingID == "" {
+		return "", newStatusError(http.StatusNotFound, "no meeting has the code %s", code)
+	}
+	return meetingID, nil
+}
+
+type meetingCodeResponse struct {
+	MeetingID string `json:"meeting_id"`
+	Code      string `json:"code"`
+	Topic     string `json:"topic"`
+	URL       string `json:"url"`
+}
+
+// apiMeetingCode tells a participant which meeting the code stands for.
+func (lkp *LiveKitPlugin) apiMeetingCode(w http.ResponseWriter, r *http.Request) {
+	meetingID, err := lkp.meetingIDOf(mux.Vars(r)["code"])
+	var meeting *model.Post
+	if err == nil {
+		meeting, _, err = lkp.authorizeMeeting(meetingID, requestUserID(r))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meetingCodeResponse{
+		MeetingID: meeting.Id,
+		Code:      codeOf(meeting),
+		Topic:     meeting.Message,
+		URL:       lkp.joinLink(meeting.Id),
+	})
+}
+
+// executeJoin handles "/liveroom join <code>", which checks the user may join before handing
+// out the link. The meeting page runs every check of /join again.
+func (lkp *LiveKitPlugin) executeJoin(args *model.CommandArgs, params []string) string {
+	if len(params) != 1 || normalizeCode(params[0]) == "" {
+		return "Usage: /liveroom join xxx-xxxx-xxx"
+	}
+	meetingID, err := lkp.meetingIDOf(params[0])
+	var meeting *model.Post
+	if err == nil {
+		meeting, _, err = lkp.authorizeMeeting(meetingID, args.UserId)
+	}
+	if err == nil {
+		err = lkp.checkLock(meeting, args.UserId)
+	}
+	if err != nil {
+		return fmt.Sprintf("You can't join this meeting: %s.", err.Error())
+	}
+	return fmt.Sprintf("**%s**\n[Join the meeting](%s)", meeting.Message, lkp.joinLink(normalizeCode(params[0])))
+}
@@ -28,6 +28,17 @@ type configuration struct {
 	TurnUDP    int
 	ApiKey     string //`json:"-"`
 	ApiValue   string //`json:"-"`
+
+	TranscriptionURL       string
+	TranscriptionKey       string //`json:"-"`
+	TranscriptionModel     string
+	TranscriptionMaxSizeMB int
+
+	RecordingRetentionDays int
+	AuditChannelID         string
+	AuditRetentionDays     int
+
+	HostFallback string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
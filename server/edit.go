@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// meetingUpdate lists the settings the host wants to change, nil fields are kept.
+type meetingUpdate struct {
+	Topic    *string `json:"topic,omitempty"`
+	Capacity *uint32 `json:"capacity,omitempty"`
+	StartAt  *int64  `json:"start_at,omitempty"`
+	Duration *int    `json:"duration,omitempty"`
+	Overflow *string `json:"overflow,omitempty"`
+}
+
+// updateMeeting applies the changes to the meeting post and its open room, then tells the
+// thread what has changed. The room metadata follows the post in MessageHasBeenUpdated.
+func (lkp *LiveKitPlugin) updateMeeting(meetingID, userID string, update meetingUpdate) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditMeetingEdit, ActorID: userID, MeetingID: meetingID}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if !lkp.isHost(meeting, userID) {
+		return nil, newStatusError(http.StatusForbidden, "only the host can edit the meeting")
+	}
+
+	changes := []string{}
+	if update.Topic != nil {
+		topic := strings.TrimSpace(*update.Topic)
+		if topic == "" {
+			return nil, newStatusError(http.StatusBadRequest, "topic can't be empty")
+		}
+		if topic != meeting.Message {
+			meeting.Message = topic
+			changes = append(changes, fmt.Sprintf("topic to %q", topic))
+		}
+	}
+	if update.Capacity != nil && int64(*update.Capacity) != numberProp(meeting, "room_capacity") {
+		meeting.AddProp("room_capacity", *update.Capacity)
+		if *update.Capacity == 0 {
+			changes = append(changes, "capacity to unlimited")
+		} else {
+			changes = append(changes, fmt.Sprintf("capacity to %d", *update.Capacity))
+		}
+	}
+	if update.Overflow != nil && *update.Overflow != overflowOf(meeting) {
+		if !validOverflow(*update.Overflow) {
+			return nil, newStatusError(http.StatusBadRequest, "unknown overflow mode %q", *update.Overflow)
+		}
+		meeting.AddProp("room_overflow", *update.Overflow)
+		switch *update.Overflow {
+		case overflowQueue:
+			changes = append(changes, "overflow to a waiting queue")
+		case overflowListen:
+			changes = append(changes, "overflow to listen-only seats")
+		default:
+			changes = append(changes, "overflow off")
+		}
+	}
+	rescheduled := false
+	if update.StartAt != nil && *update.StartAt != numberProp(meeting, "room_start") {
+		if *update.StartAt < 0 {
+			return nil, newStatusError(http.StatusBadRequest, "start time can't be negative")
+		}
+		meeting.AddProp("room_start", *update.StartAt)
+		rescheduled = true
+		changes = append(changes, "start time to "+model.GetTimeForMillis(*update.StartAt).UTC().Format("Mon, 02 Jan 2006 15:04 MST"))
+	}
+	if update.Duration != nil && int64(*update.Duration) != numberProp(meeting, "room_duration") {
+		if *update.Duration < 1 {
+			return nil, newStatusError(http.StatusBadRequest, "duration must be positive")
+		}
+		meeting.AddProp("room_duration", *update.Duration)
+		rescheduled = true
+		changes = append(changes, fmt.Sprintf("duration to %d minutes", *update.Duration))
+	}
+	if len(changes) == 0 {
+		return meeting, nil
+	}
+	event.Details = strings.Join(changes, ", ")
+	if (update.Capacity != nil || update.Overflow != nil) && roomLimit(meeting) == 0 {
+		if err := lkp.checkLimitLift(meeting); err != nil {
+			return nil, err
+		}
+	}
+
+	model.ParseSlackAttachment(meeting, lkp.meetingAttachments(meeting))
+	updated, appErr := lkp.API.UpdatePost(meeting)
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to update meeting post")
+	}
+	if options := optionsOf(updated); options.StartAt > 0 && (rescheduled || update.Topic != nil) {
+		if options.Duration < 1 {
+			options.Duration = 60
+		}
+		if err := lkp.scheduleMeeting(updated, options); err != nil {
+			lkp.API.LogError("meeting schedule not updated", "meeting", updated.Id, "reason", err.Error())
+		}
+	}
+	if update.Capacity != nil || update.Overflow != nil {
+		if err := lkp.resizeRoom(updated); err != nil {
+			lkp.API.LogWarn("room capacity not updated", "room", updated.Id, "reason", err.Error())
+		}
+	}
+
+	editor := "The host"
+	if user, appErr := lkp.API.GetUser(userID); appErr == nil {
+		editor = "@" + user.Username
+	}
+	lkp.threadReply(updated, fmt.Sprintf("%s changed the %s.", editor, strings.Join(changes, ", ")))
+	lkp.API.LogInfo("meeting edited", "meeting", updated.Id, "user_id", userID, "changes", strings.Join(changes, "; "))
+	return updated, nil
+}
+
+// resizeRoom applies the participant limit of the meeting to its open room. LiveKit has no call to change
+// the limit of a room, but CreateRoom updates the settings of a room which already exists. It only
+// applies a limit above 0 though, so checkLimitLift keeps the limit of an open room from being lifted.
+func (lkp *LiveKitPlugin) resizeRoom(meeting *model.Post) error {
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{Names: []string{meeting.Id}})
+	if err != nil {
+		return errors.Wrap(err, "failed to look up room")
+	}
+	if len(roomList.Rooms) == 0 {
+		return nil
+	}
+	_, err = lkp.master.CreateRoom(
+		context.Background(),
+		&livekit.CreateRoomRequest{
+			Name:            meeting.Id,
+			EmptyTimeout:    roomList.Rooms[0].EmptyTimeout,
+			MaxParticipants: roomLimit(meeting),
+		},
+	)
+	return err
+}
+
+// checkLimitLift refuses to make the room unlimited while it is open, which happens with
+// capacity 0 and with listen-only overflow, as LiveKit would keep enforcing the old limit.
+func (lkp *LiveKitPlugin) checkLimitLift(meeting *model.Post) error {
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{Names: []string{meeting.Id}})
+	if err != nil {
+		return errors.Wrap(err, "failed to look up room")
+	}
+	if len(roomList.Rooms) > 0 && roomList.Rooms[0].MaxParticipants > 0 {
+		return newStatusError(http.StatusConflict, "the participant limit of an open room can't be lifted, change it once the room has closed")
+	}
+	return nil
+}
+
+// executeEdit handles `/liveroom edit topic "new topic"`, `/liveroom edit capacity N` and
+// `/liveroom edit overflow none|queue|listen`, run from the meeting thread.
+func (lkp *LiveKitPlugin) executeEdit(args *model.CommandArgs, params []string) string {
+	usage := `Usage: /liveroom edit topic "new topic" | capacity N | overflow none|queue|listen`
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if len(params) < 2 {
+		return usage
+	}
+	update := meetingUpdate{}
+	switch params[0] {
+	case "topic":
+		splitted := strings.Split(args.Command, "\"")
+		if len(splitted) != 3 {
+			return usage
+		}
+		update.Topic = &splitted[1]
+	case "capacity":
+		capacity, err := strconv.ParseUint(params[1], 10, 32)
+		if err != nil || len(params) != 2 {
+			return usage
+		}
+		limit := uint32(capacity)
+		update.Capacity = &limit
+	case "overflow":
+		mode := params[1]
+		if mode == "none" {
+			mode = ""
+		}
+		if len(params) != 2 || !validOverflow(mode) {
+			return usage
+		}
+		update.Overflow = &mode
+	default:
+		return usage
+	}
+	if _, err := lkp.updateMeeting(args.RootId, args.UserId, update); err != nil {
+		return fmt.Sprintf("The meeting was not changed: %s.", err.Error())
+	}
+	return "Meeting updated."
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// endMeeting closes the meeting for everyone on request of its hosts or an admin.
+func (lkp *LiveKitPlugin) endMeeting(meetingID, userID string) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditMeetingEnd, ActorID: userID, MeetingID: meetingID}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if !lkp.canManageMeeting(meeting, userID) {
+		return nil, newStatusError(http.StatusForbidden, "only the hosts and admins can end the meeting")
+	}
+	return lkp.closeMeeting(meeting, fmt.Sprintf("Meeting ended by %s", lkp.mention(userID)), userID)
+}
+
+// closeMeeting disconnects the participants and marks the post with a final summary, so
+// nobody can join again. The post and its thread stay in the channel as the record.
+func (lkp *LiveKitPlugin) closeMeeting(meeting *model.Post, headline, userID string) (*model.Post, error) {
+	summary := []string{headline}
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{Names: []string{meeting.Id}})
+	if err == nil && len(roomList.Rooms) > 0 {
+		started := time.Unix(roomList.Rooms[0].CreationTime, 0)
+		summary[0] += fmt.Sprintf(" after %d minutes", int(time.Since(started).Minutes()))
+		names := []string{}
+		if participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: meeting.Id}); err == nil {
+			for _, participant := range participantList.Participants {
+				if participant.Identity == lkp.botUserID {
+					continue
+				}
+				if participant.Name != "" {
+					names = append(names, participant.Name)
+				} else {
+					names = append(names, lkp.mention(participant.Identity))
+				}
+			}
+		}
+		if len(names) > 0 {
+			summary = append(summary, "Participants at the end: "+strings.Join(names, ", "))
+		}
+	}
+	summaryText := strings.Join(summary, ". ") + "."
+
+	if session, err := lkp.getBreakout(meeting.Id); err == nil && session != nil {
+		if err := lkp.stopBreakout(meeting.Id); err != nil {
+			lkp.API.LogWarn("breakout not closed", "meeting", meeting.Id, "reason", err.Error())
+		}
+	}
+	if err := lkp.sdk.KV.Delete(queueKeyPrefix + meeting.Id); err != nil {
+		lkp.API.LogWarn("meeting queue not cleared", "meeting", meeting.Id, "reason", err.Error())
+	}
+	if err := lkp.sdk.KV.Delete(liveKeyPrefix + meeting.Id); err != nil {
+		lkp.API.LogWarn("live meeting not unindexed", "meeting", meeting.Id, "reason", err.Error())
+	}
+	lkp.closeInvites(meeting.Id)
+	lkp.unscheduleMeeting(meeting.Id)
+
+	meeting.AddProp("room_status", "ended")
+	meeting.AddProp("room_live", false)
+	meeting.AddProp("room_ended_at", model.GetMillis())
+	meeting.AddProp("room_ended_by", userID)
+	meeting.AddProp("room_summary", summaryText)
+	model.ParseSlackAttachment(meeting, lkp.meetingAttachments(meeting))
+	updated, appErr := lkp.API.UpdatePost(meeting)
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to update meeting post")
+	}
+	// The room is deleted once the post says the meeting has ended, so the room_finished
+	// webhook it triggers finds the meeting over.
+	if _, err := lkp.master.DeleteRoom(context.Background(), &livekit.DeleteRoomRequest{Room: meeting.Id}); err != nil {
+		lkp.API.LogWarn("room not deleted", "room", meeting.Id, "reason", err.Error())
+	}
+	lkp.threadReply(updated, summaryText)
+	lkp.API.LogInfo("meeting ended", "meeting", updated.Id, "user_id", userID)
+	return updated, nil
+}
+
+// executeEnd handles "/liveroom end" in the meeting thread.
+func (lkp *LiveKitPlugin) executeEnd(args *model.CommandArgs) string {
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if _, err := lkp.endMeeting(args.RootId, args.UserId); err != nil {
+		return fmt.Sprintf("The meeting was not ended: %s.", err.Error())
+	}
+	return "The meeting has ended."
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// historyKeyPrefix is followed by the user id and the meeting id.
+	historyKeyPrefix = "history_"
+	// historyIndexKeyPrefix is followed by the user id and lists the meetings in the history
+	// of the user, so it is read without walking the KV store.
+	historyIndexKeyPrefix = "history_index_"
+)
+
+// attendance is what the plugin remembers about a meeting for one user. Seconds adds up the
+// time spent in the meeting and its breakout rooms; ActiveSince is set while the user is in.
+type attendance struct {
+	MeetingID   string `json:"meeting_id"`
+	ChannelID   string `json:"channel_id"`
+	Topic       string `json:"topic"`
+	Created     bool   `json:"created"`
+	Joined      bool   `json:"joined"`
+	At          int64  `json:"at"`
+	Seconds     int64  `json:"seconds"`
+	ActiveSince int64  `json:"active_since,omitempty"`
+	Sessions    int    `json:"sessions,omitempty"`
+}
+
+// historyEntry is an attendance as the user gets to see it.
+type historyEntry struct {
+	MeetingID   string `json:"meeting_id"`
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	Topic       string `json:"topic"`
+	Created     bool   `json:"created"`
+	Joined      bool   `json:"joined"`
+	At          int64  `json:"at"`
+	Duration    int64  `json:"duration"`
+	Permalink   string `json:"permalink"`
+}
+
+type historyPage struct {
+	Entries []*historyEntry `json:"entries"`
+	Total   int             `json:"total"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+}
+
+func historyKey(userID, meetingID string) string {
+	return historyKeyPrefix + userID + "_" + meetingID
+}
+
+// updateAttendance changes the attendance of the user at the meeting, creating it first.
+func (lkp *LiveKitPlugin) updateAttendance(userID string, meeting *model.Post, update func(*attendance)) error {
+	created := false
+	err := lkp.sdk.KV.SetAtomicWithRetries(historyKey(userID, meeting.Id), func(oldValue []byte) (interface{}, error) {
+		record := &attendance{MeetingID: meeting.Id, At: model.GetMillis()}
+		created = oldValue == nil
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, record); err != nil {
+				return nil, err
+			}
+		}
+		record.ChannelID = meeting.ChannelId
+		record.Topic = meeting.Message
+		update(record)
+		return record, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save attendance")
+	}
+	if !created {
+		return nil
+	}
+	return lkp.updateHistoryIndex(userID, func(meetingIDs []string) []string {
+		for _, meetingID := range meetingIDs {
+			if meetingID == meeting.Id {
+				return meetingIDs
+			}
+		}
+		return append(meetingIDs, meeting.Id)
+	})
+}
+
+// getHistoryIndex returns the ids of the meetings in the history of the user. The list is built
+// from the KV store the first time, for the history saved before it existed.
+func (lkp *LiveKitPlugin) getHistoryIndex(userID string) ([]string, error) {
+	var meetingIDs []string
+	if err := lkp.sdk.KV.Get(historyIndexKeyPrefix+userID, &meetingIDs); err != nil {
+		return nil, errors.Wrap(err, "failed to read meeting history")
+	}
+	if meetingIDs != nil {
+		return meetingIDs, nil
+	}
+	prefix := historyKeyPrefix + userID + "_"
+	keys, err := lkp.listKeys(prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list meeting history")
+	}
+	err = lkp.updateHistoryIndex(userID, func(meetingIDs []string) []string {
+		known := map[string]bool{}
+		for _, meetingID := range meetingIDs {
+			known[meetingID] = true
+		}
+		for _, key := range keys {
+			if meetingID := strings.TrimPrefix(key, prefix); !known[meetingID] {
+				meetingIDs = append(meetingIDs, meetingID)
+			}
+		}
+		return meetingIDs
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lkp.getHistoryIndex(userID)
+}
+
+func (lkp *LiveKitPlugin) updateHistoryIndex(userID string, update func([]string) []string) error {
+	err := lkp.sdk.KV.SetAtomicWithRetries(historyIndexKeyPrefix+userID, func(oldValue []byte) (interface{}, error) {
+		meetingIDs := []string{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &meetingIDs); err != nil {
+				return nil, err
+			}
+		}
+		return update(meetingIDs), nil
+	})
+	return errors.Wrap(err, "failed to save meeting history")
+}
+
+// recordCreated adds the meeting to the history of its creator.
+func (lkp *LiveKitPlugin) recordCreated(meeting *model.Post, userID string) {
+	err := lkp.updateAttendance(userID, meeting, func(record *attendance) {
+		record.Created = true
+		if start := numberProp(meeting, "room_start"); start > 0 {
+			record.At = start
+		}
+	})
+	if err != nil {
+		lkp.API.LogError("meeting history not saved", "meeting", meeting.Id, "user_id", userID, "reason", err.Error())
+	}
+}
+
+// trackAttendance follows the participant_joined and participant_left webhooks. Moving to a
+// breakout room and back counts as staying in the meeting.
+func (lkp *LiveKitPlugin) trackAttendance(room *livekit.Room, participant *livekit.ParticipantInfo, joined bool) {
+	if room == nil || participant == nil || participant.Identity == lkp.botUserID {
+		return
+	}
+	meeting, err := lkp.getMeeting(meetingOfRoom(room.Name))
+	if err != nil {
+		return
+	}
+	now := model.GetMillis()
+	err = lkp.updateAttendance(participant.Identity, meeting, func(record *attendance) {
+		if joined {
+			if !record.Joined {
+				record.Joined = true
+				record.At = now
+			}
+			if record.Sessions == 0 {
+				record.ActiveSince = now
+			}
+			record.Sessions++
+			return
+		}
+		if record.Sessions > 0 {
+			record.Sessions--
+		}
+		if record.Sessions == 0 && record.ActiveSince > 0 {
+			record.Seconds += (now - record.ActiveSince) / 1000
+			record.ActiveSince = 0
+		}
+	})
+	if err != nil {
+		lkp.API.LogError("meeting history not saved", "meeting", meeting.Id, "user_id", participant.Identity, "reason", err.Error())
+	}
+}
+
+// permalink opens the post in whatever team it belongs to.
+func (lkp *LiveKitPlugin) permalink(postID string) string {
+	return fmt.Sprintf("%s/_redirect/pl/%s", lkp.siteURL(), postID)
+}
+
+// meetingHistory returns the page of the meetings the user created or joined between since
+// and until, in milliseconds, newest first.
+func (lkp *LiveKitPlugin) meetingHistory(userID string, since, until int64, page, perPage int) (*historyPage, error) {
+	records := []*attendance{}
+	meetingIDs, err := lkp.getHistoryIndex(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, meetingID := range meetingIDs {
+		var record *attendance
+		if err := lkp.sdk.KV.Get(historyKey(userID, meetingID), &record); err != nil {
+			return nil, errors.Wrap(err, "failed to read meeting history")
+		}
+		if record != nil && record.At >= since && record.At <= until {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].At > records[j].At })
+
+	result := &historyPage{Entries: []*historyEntry{}, Total: len(records), Page: page, PerPage: perPage}
+	now := model.GetMillis()
+	channels := map[string]string{}
+	for i := page * perPage; i < len(records) && i < (page+1)*perPage; i++ {
+		record := records[i]
+		seconds := record.Seconds
+		if record.ActiveSince > 0 {
+			seconds += (now - record.ActiveSince) / 1000
+		}
+		channelName, found := channels[record.ChannelID]
+		if !found {
+			if channel, appErr := lkp.API.GetChannel(record.ChannelID); appErr == nil {
+				channelName = channel.DisplayName
+			}
+			channels[record.ChannelID] = channelName
+		}
+		result.Entries = append(result.Entries, &historyEntry{
+			MeetingID:   record.MeetingID,
+			ChannelID:   record.ChannelID,
+			ChannelName: channelName,
+			Topic:       record.Topic,
+			Created:     record.Created,
+			Joined:      record.Joined,
+			At:          record.At,
+			Duration:    seconds,
+			Permalink:   lkp.permalink(record.MeetingID),
+		})
+	}
+	return result, nil
+}
+
+// apiHistory serves GET /history?since=&until=&page=&per_page=, the window defaulting to the
+// last 30 days.
+func (lkp *LiveKitPlugin) apiHistory(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	until := model.GetMillis()
+	page, perPage := 0, 20
+	var err error
+	if value := values.Get("until"); value != "" {
+		if until, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, newStatusError(http.StatusBadRequest, "until must be a time in milliseconds"))
+			return
+		}
+	}
+	since := until - 30*24*time.Hour.Milliseconds()
+	if value := values.Get("since"); value != "" {
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, newStatusError(http.StatusBadRequest, "since must be a time in milliseconds"))
+			return
+		}
+	}
+	if value := values.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 0 {
+			writeError(w, newStatusError(http.StatusBadRequest, "page must not be negative"))
+			return
+		}
+	}
+	if value := values.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 || perPage > 200 {
+			writeError(w, newStatusError(http.StatusBadRequest, "per_page must be between 1 and 200"))
+			return
+		}
+	}
+	history, err := lkp.meetingHistory(requestUserID(r), since, until, page, perPage)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// executeHistory handles "/liveroom history [days|YYYY-MM-DD [YYYY-MM-DD]] [page N]", the
+// dates being read in the user's own timezone.
+func (lkp *LiveKitPlugin) executeHistory(args *model.CommandArgs, params []string) string {
+	usage := "Usage: /liveroom history [days | YYYY-MM-DD [YYYY-MM-DD]] [page N]"
+	location := time.UTC
+	if user, appErr := lkp.API.GetUser(args.UserId); appErr == nil {
+		if userLocation, err := time.LoadLocation(user.GetPreferredTimezone()); err == nil {
+			location = userLocation
+		}
+	}
+	now := time.Now().In(location)
+	since, until := now.AddDate(0, 0, -7), now
+	page := 0
+	dates := []time.Time{}
+	for i := 0; i < len(params); i++ {
+		if params[i] == "page" && i+1 < len(params) {
+			number, err := strconv.Atoi(params[i+1])
+			if err != nil || number < 1 {
+				return usage
+			}
+			page = number - 1
+			i++
+			continue
+		}
+		if days, err := strconv.Atoi(params[i]); err == nil && days > 0 {
+			since = now.AddDate(0, 0, -days)
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", params[i], location)
+		if err != nil {
+			return usage
+		}
+		dates = append(dates, date)
+	}
+	switch len(dates) {
+	case 0:
+	case 1:
+		since, until = dates[0], dates[0].AddDate(0, 0, 1)
+	case 2:
+		since, until = dates[0], dates[1].AddDate(0, 0, 1)
+	default:
+		return usage
+	}
+
+	history, err := lkp.meetingHistory(args.UserId, model.GetMillisForTime(since), model.GetMillisForTime(until), page, 10)
+	if err != nil {
+		return err.Error()
+	}
+	if history.Total == 0 {
+		return fmt.Sprintf("You had no meetings between %s and %s.", since.Format("2006-01-02"), until.Format("2006-01-02"))
+	}
+	if len(history.Entries) == 0 {
+		return fmt.Sprintf("There are only %d meetings, page %d is empty.", history.Total, page+1)
+	}
+	lines := []string{
+		"| Date | Channel | Topic | Duration | |",
+		"|:-----|:--------|:------|:---------|:--|",
+	}
+	for _, entry := range history.Entries {
+		duration := "-"
+		if entry.Joined {
+			duration = fmt.Sprintf("%d min", (entry.Duration+30)/60)
+		}
+		topic := entry.Topic
+		if entry.Created {
+			topic += " (created)"
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s | [Open](%s) |",
+			model.GetTimeForMillis(entry.At).In(location).Format("Mon 2006-01-02 15:04"),
+			entry.ChannelName, strings.ReplaceAll(topic, "|", "\\|"), duration, entry.Permalink))
+	}
+	pages := (history.Total + history.PerPage - 1) / history.PerPage
+	lines = append(lines, "", fmt.Sprintf("Page %d of %d, %d meetings.", page+1, pages, history.Total))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	fallbackCoHost      = "cohost"
+	fallbackAdmin       = "admin"
+	fallbackParticipant = "participant"
+	fallbackNone        = "none"
+)
+
+// hostGracePeriod lets a host who lost the connection come back before the role moves on.
+const hostGracePeriod = 30 * time.Second
+
+func hostOf(meeting *model.Post) string {
+	host, _ := meeting.GetProp("room_host").(string)
+	return host
+}
+
+func coHostsOf(meeting *model.Post) []string {
+	return stringsProp(meeting, "room_cohosts")
+}
+
+// canAssignHosts tells whether the user may change the hosts: the host and channel admins,
+// co-hosts can't promote anyone.
+func (lkp *LiveKitPlugin) canAssignHosts(meeting *model.Post, userID string) bool {
+	return hostOf(meeting) == userID || lkp.isChannelAdmin(meeting.ChannelId, userID)
+}
+
+// hostsMeeting loads the meeting for a change of its hosts and checks the target user.
+func (lkp *LiveKitPlugin) hostsMeeting(meetingID, actorID, userID string) (*model.Post, error) {
+	meeting, err := lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if !lkp.canAssignHosts(meeting, actorID) {
+		return nil, newStatusError(http.StatusForbidden, "only the host and admins can change the hosts")
+	}
+	if _, appErr := lkp.API.GetChannelMember(meeting.ChannelId, userID); appErr != nil {
+		return nil, newStatusError(http.StatusBadRequest, "the user is not a member of this channel")
+	}
+	return meeting, nil
+}
+
+// setCoHost adds the user to the co-hosts of the meeting, or removes them.
+func (lkp *LiveKitPlugin) setCoHost(meetingID, actorID, userID string, on bool) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditCoHostAdd, ActorID: actorID, MeetingID: meetingID, TargetID: userID}
+	if !on {
+		event.Action = auditCoHostRemove
+	}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.hostsMeeting(meetingID, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if hostOf(meeting) == userID {
+		return nil, newStatusError(http.StatusBadRequest, "the user is already the host")
+	}
+	coHosts := []string{}
+	found := false
+	for _, coHost := range coHostsOf(meeting) {
+		if coHost == userID {
+			found = true
+		} else {
+			coHosts = append(coHosts, coHost)
+		}
+	}
+	if found == on {
+		return meeting, nil
+	}
+	if on {
+		coHosts = append(coHosts, userID)
+	}
+	meeting.AddProp("room_cohosts", coHosts)
+	updated, appErr := lkp.API.UpdatePost(meeting)
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to update meeting post")
+	}
+	if on {
+		lkp.threadReply(updated, fmt.Sprintf("%s is now a co-host.", lkp.mention(userID)))
+	} else {
+		lkp.threadReply(updated, fmt.Sprintf("%s is no longer a co-host.", lkp.mention(userID)))
+	}
+	lkp.API.LogInfo("meeting co-host changed", "meeting", updated.Id, "user_id", userID, "co_host", on, "by", actorID)
+	return updated, nil
+}
+
+// transferHost hands the host role to the user on request of the host or an admin.
+func (lkp *LiveKitPlugin) transferHost(meetingID, actorID, userID string) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditHostTransfer, ActorID: actorID, MeetingID: meetingID, TargetID: userID}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.hostsMeeting(meetingID, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if hostOf(meeting) == userID {
+		return meeting, nil
+	}
+	return lkp.assignHost(meeting, userID, fmt.Sprintf("%s handed the host role to %s.", lkp.mention(actorID), lkp.mention(userID)))
+}
+
+// assignHost makes the user the host of the meeting. The previous host stays on as a
+// co-host, so they keep managing the meeting if they come back.
+func (lkp *LiveKitPlugin) assignHost(meeting *model.Post, userID, announcement string) (*model.Post, error) {
+	previous := hostOf(meeting)
+	coHosts := []string{}
+	for _, coHost := range coHostsOf(meeting) {
+		if coHost != userID && coHost != previous {
+			coHosts = append(coHosts, coHost)
+		}
+	}
+	if previous != "" {
+		coHosts = append(coHosts, previous)
+	}
+	meeting.AddProp("room_host", userID)
+	meeting.AddProp("room_cohosts", coHosts)
+	updated, appErr := lkp.API.UpdatePost(meeting)
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to update meeting post")
+	}
+	lkp.threadReply(updated, announcement)
+	lkp.API.LogInfo("meeting host changed", "meeting", updated.Id, "from", previous, "to", userID)
+	return updated, nil
+}
+
+// mention names the user in a thread reply.
+func (lkp *LiveKitPlugin) mention(userID string) string {
+	if user, appErr := lkp.API.GetUser(userID); appErr == nil {
+		return "@" + user.Username
+	}
+	return "Someone"
+}
+
+// onHostLeft passes the host role on when the host leaves the meeting room and doesn't come
+// back within the grace period. HostFallback decides who may take it over.
+func (lkp *LiveKitPlugin) onHostLeft(room *livekit.Room, participant *livekit.ParticipantInfo) {
+	if room == nil || participant == nil || meetingOfRoom(room.Name) != room.Name {
+		return
+	}
+	fallback := lkp.getConfiguration().HostFallback
+	if fallback == fallbackNone {
+		return
+	}
+	meeting, err := lkp.getMeeting(room.Name)
+	if err != nil || meetingEnded(meeting) || hostOf(meeting) != participant.Identity {
+		return
+	}
+	time.Sleep(hostGracePeriod)
+	if lkp.inRoom(room.Name, participant.Identity) {
+		return
+	}
+	if meeting, err = lkp.getMeeting(room.Name); err != nil || hostOf(meeting) != participant.Identity {
+		return
+	}
+	userID := lkp.fallbackHost(meeting, fallback)
+	if userID == "" {
+		lkp.API.LogInfo("no fallback host present", "meeting", meeting.Id)
+		return
+	}
+	announcement := fmt.Sprintf("The host has left, %s is the host now.", lkp.mention(userID))
+	_, err = lkp.assignHost(meeting, userID, announcement)
+	if err != nil {
+		lkp.API.LogError("fallback host not assigned", "meeting", meeting.Id, "reason", err.Error())
+	}
+	lkp.audit(auditEvent{Action: auditHostFallback, MeetingID: meeting.Id, ChannelID: meeting.ChannelId, TargetID: userID, Details: fallback}, err)
+}
+
+// fallbackHost picks the participant who has been in the room the longest among the
+// co-hosts, then the channel admins and, if the policy allows, everyone else.
+func (lkp *LiveKitPlugin) fallbackHost(meeting *model.Post, fallback string) string {
+	participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: meeting.Id})
+	if err != nil {
+		return ""
+	}
+	participants := []*livekit.ParticipantInfo{}
+	for _, participant := range participantList.Participants {
+		if participant.Identity != lkp.botUserID && !isListener(participant) {
+			participants = append(participants, participant)
+		}
+	}
+	sort.Slice(participants, func(i, j int) bool { return participants[i].JoinedAt < participants[j].JoinedAt })
+
+	coHosts := map[string]bool{}
+	for _, coHost := range coHostsOf(meeting) {
+		coHosts[coHost] = true
+	}
+	candidates := []func(string) bool{}
+	if fallback != fallbackAdmin {
+		candidates = append(candidates, func(userID string) bool { return coHosts[userID] })
+	}
+	candidates = append(candidates, func(userID string) bool { return lkp.isChannelAdmin(meeting.ChannelId, userID) })
+	if fallback == fallbackParticipant {
+		candidates = append(candidates, func(userID string) bool { return true })
+	}
+	for _, eligible := range candidates {
+		for _, participant := range participants {
+			if eligible(participant.Identity) {
+				return participant.Identity
+			}
+		}
+	}
+	return ""
+}
+
+// executeHosts handles "/liveroom cohost @user [remove]" and "/liveroom host @user" in the
+// meeting thread.
+func (lkp *LiveKitPlugin) executeHosts(args *model.CommandArgs, subcommand string, params []string) string {
+	usage := "Usage: /liveroom cohost @user [remove] or /liveroom host @user"
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if len(params) == 0 || len(params) > 2 || (len(params) == 2 && (subcommand != "cohost" || params[1] != "remove")) {
+		return usage
+	}
+	user, appErr := lkp.API.GetUserByUsername(strings.TrimPrefix(params[0], "@"))
+	if appErr != nil {
+		return fmt.Sprintf("User %s was not found.", params[0])
+	}
+	var err error
+	if subcommand == "host" {
+		_, err = lkp.transferHost(args.RootId, args.UserId, user.Id)
+	} else {
+		_, err = lkp.setCoHost(args.RootId, args.UserId, user.Id, len(params) == 1)
+	}
+	if err != nil {
+		return fmt.Sprintf("The hosts were not changed: %s.", err.Error())
+	}
+	return "Hosts updated."
+}
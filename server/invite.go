@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const inviteKeyPrefix = "invite_"
+
+// invitation lets a user into a single meeting, even when they are not a member of its
+// channel. The answer is 0 until the user accepts or declines.
+type invitation struct {
+	UserID     string `json:"user_id"`
+	InvitedBy  string `json:"invited_by"`
+	InvitedAt  int64  `json:"invited_at"`
+	AcceptedAt int64  `json:"accepted_at,omitempty"`
+	DeclinedAt int64  `json:"declined_at,omitempty"`
+}
+
+func (invite *invitation) status() string {
+	switch {
+	case invite.AcceptedAt > 0:
+		return "accepted"
+	case invite.DeclinedAt > 0:
+		return "declined"
+	}
+	return "pending"
+}
+
+// getInvites returns the invitations of the meeting, keyed by user id.
+func (lkp *LiveKitPlugin) getInvites(meetingID string) (map[string]*invitation, error) {
+	invites := map[string]*invitation{}
+	if err := lkp.sdk.KV.Get(inviteKeyPrefix+meetingID, &invites); err != nil {
+		return nil, errors.Wrap(err, "failed to read invitations")
+	}
+	return invites, nil
+}
+
+// updateInvites changes the invitations of the meeting atomically.
+func (lkp *LiveKitPlugin) updateInvites(meetingID string, update func(map[string]*invitation) error) error {
+	return lkp.sdk.KV.SetAtomicWithRetries(inviteKeyPrefix+meetingID, func(oldValue []byte) (interface{}, error) {
+		invites := map[string]*invitation{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &invites); err != nil {
+				return nil, err
+			}
+		}
+		if err := update(invites); err != nil {
+			return nil, err
+		}
+		return invites, nil
+	})
+}
+
+// isInvited tells whether the user was invited to the meeting and hasn't declined.
+func (lkp *LiveKitPlugin) isInvited(meetingID, userID string) bool {
+	invites, err := lkp.getInvites(meetingID)
+	if err != nil {
+		return false
+	}
+	invite, found := invites[userID]
+	return found && invite.DeclinedAt == 0
+}
+
+// sortedInvites lists the invitations in the order they were sent.
+func sortedInvites(invites map[string]*invitation) []*invitation {
+	list := []*invitation{}
+	for _, invite := range invites {
+		list = append(list, invite)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].InvitedAt < list[j].InvitedAt })
+	return list
+}
+
+// managedMeeting loads the meeting for one of its hosts or an admin.
+func (lkp *LiveKitPlugin) managedMeeting(meetingID, userID string) (*model.Post, error) {
+	meeting, err := lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if !lkp.canManageMeeting(meeting, userID) {
+		return nil, newStatusError(http.StatusForbidden, "only the hosts and admins can invite to the meeting")
+	}
+	return meeting, nil
+}
+
+// inviteUsers records the invitations and sends each invitee a direct message with buttons
+// to accept or decline. Inviting someone again sends a new message and resets their answer.
+func (lkp *LiveKitPlugin) inviteUsers(meetingID, actorID string, userIDs []string) ([]*invitation, error) {
+	meeting, err := lkp.managedMeeting(meetingID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(userIDs) == 0 {
+		return nil, newStatusError(http.StatusBadRequest, "nobody to invite")
+	}
+	for _, userID := range userIDs {
+		user, appErr := lkp.API.GetUser(userID)
+		if appErr != nil || user.DeleteAt != 0 || user.IsBot {
+			return nil, newStatusError(http.StatusBadRequest, "user %s can't be invited", userID)
+		}
+	}
+	now := model.GetMillis()
+	err = lkp.updateInvites(meeting.Id, func(invites map[string]*invitation) error {
+		for _, userID := range userIDs {
+			invites[userID] = &invitation{UserID: userID, InvitedBy: actorID, InvitedAt: now}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save invitations")
+	}
+
+	invited := []*invitation{}
+	mentions := []string{}
+	for _, userID := range userIDs {
+		lkp.audit(auditEvent{Action: auditMeetingInvite, ActorID: actorID, MeetingID: meeting.Id, ChannelID: meeting.ChannelId, TargetID: userID}, nil)
+		if err := lkp.sendInvite(meeting, actorID, userID); err != nil {
+			lkp.API.LogWarn("invitation not sent", "meeting", meeting.Id, "user_id", userID, "reason", err.Error())
+		}
+		invited = append(invited, &invitation{UserID: userID, InvitedBy: actorID, InvitedAt: now})
+		mentions = append(mentions, lkp.mention(userID))
+	}
+	lkp.threadReply(meeting, fmt.Sprintf("%s invited %s.", lkp.mention(actorID), strings.Join(mentions, ", ")))
+	return invited, nil
+}
+
+// sendInvite posts the invitation to the direct channel between the bot and the user.
+func (lkp *LiveKitPlugin) sendInvite(meeting *model.Post, actorID, userID string) error {
+	channel, appErr := lkp.API.GetDirectChannel(userID, lkp.botUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get direct channel")
+	}
+	text := fmt.Sprintf("%s invites you to the meeting **%s**", lkp.mention(actorID), meeting.Message)
+	if meetingChannel, appErr := lkp.API.GetChannel(meeting.ChannelId); appErr == nil && meetingChannel.DisplayName != "" {
+		text += " in " + meetingChannel.DisplayName
+	}
+	action := func(id, name, style string) *model.PostAction {
+		return &model.PostAction{
+			Id:    id,
+			Type:  model.PostActionTypeButton,
+			Name:  name,
+			Style: style,
+			Integration: &model.PostActionIntegration{
+				URL:     fmt.Sprintf("/plugins/%s/action", pluginID),
+				Context: map[string]interface{}{"action": id, "meeting_id": meeting.Id},
+			},
+		}
+	}
+	post := &model.Post{UserId: lkp.botUserID, ChannelId: channel.Id}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Fallback: "Meeting invitation: " + meeting.Message,
+		Title:    meeting.Message,
+		Text:     text + ".",
+		Actions: []*model.PostAction{
+			action(actionAccept, "Accept and join", "primary"),
+			action(actionDecline, "Decline", "default"),
+		},
+	}})
+	if _, appErr := lkp.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to post invitation")
+	}
+	return nil
+}
+
+// answerInvite saves whether the invitee comes and tells the meeting thread.
+func (lkp *LiveKitPlugin) answerInvite(meetingID, userID string, accepted bool) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditInviteAccept, ActorID: userID, MeetingID: meetingID}
+	if !accepted {
+		event.Action = auditInviteDecline
+	}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	invites, err := lkp.getInvites(meeting.Id)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := invites[userID]; !found {
+		return nil, newStatusError(http.StatusNotFound, "you are not invited to this meeting")
+	}
+	changed := false
+	err = lkp.updateInvites(meeting.Id, func(invites map[string]*invitation) error {
+		invite, found := invites[userID]
+		if !found {
+			return errors.New("invitation was withdrawn")
+		}
+		previous := invite.status()
+		invite.AcceptedAt, invite.DeclinedAt = 0, 0
+		if accepted {
+			invite.AcceptedAt = model.GetMillis()
+		} else {
+			invite.DeclinedAt = model.GetMillis()
+		}
+		changed = invite.status() != previous
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save the answer")
+	}
+	if changed {
+		if accepted {
+			lkp.threadReply(meeting, fmt.Sprintf("%s accepted the invitation.", lkp.mention(userID)))
+		} else {
+			lkp.threadReply(meeting, fmt.Sprintf("%s declined the invitation.", lkp.mention(userID)))
+		}
+	}
+	return meeting, nil
+}
+
+// closeInvites forgets the invitations of a meeting which has ended.
+func (lkp *LiveKitPlugin) closeInvites(meetingID string) {
+	if err := lkp.sdk.KV.Delete(inviteKeyPrefix + meetingID); err != nil {
+		lkp.API.LogWarn("meeting invitations not cleared", "meeting", meetingID, "reason", err.Error())
+	}
+}
+
+type inviteRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+type inviteStatus struct {
+	*invitation
+	Status string `json:"status"`
+	InRoom bool   `json:"in_room"`
+}
+
+func (lkp *LiveKitPlugin) apiInvite(w http.ResponseWriter, r *http.Request) {
+	var request inviteRequest
+	if err := decodeBody(r, &request); err != nil {
+		writeError(w, err)
+		return
+	}
+	invites, err := lkp.inviteUsers(mux.Vars(r)["id"], requestUserID(r), request.UserIDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, invites)
+}
+
+// apiListInvites shows the hosts who was invited and who has answered.
+func (lkp *LiveKitPlugin) apiListInvites(w http.ResponseWriter, r *http.Request) {
+	meeting, err := lkp.managedMeeting(mux.Vars(r)["id"], requestUserID(r))
+	var invites map[string]*invitation
+	if err == nil {
+		invites, err = lkp.getInvites(meeting.Id)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	statuses := []*inviteStatus{}
+	for _, invite := range sortedInvites(invites) {
+		statuses = append(statuses, &inviteStatus{invitation: invite, Status: invite.status(), InRoom: lkp.inRoom(meeting.Id, invite.UserID)})
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// executeInvite handles "/liveroom invite @user ..." in the meeting thread. Without users it
+// lists the invitations and their answers.
+func (lkp *LiveKitPlugin) executeInvite(args *model.CommandArgs, params []string) string {
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if len(params) == 0 {
+		meeting, err := lkp.managedMeeting(args.RootId, args.UserId)
+		var invites map[string]*invitation
+		if err == nil {
+			invites, err = lkp.getInvites(meeting.Id)
+		}
+		if err != nil {
+			return fmt.Sprintf("The invitations were not read: %s.", err.Error())
+		}
+		if len(invites) == 0 {
+			return "Nobody was invited yet. Usage: /liveroom invite @user ..."
+		}
+		lines := []string{"Invitations:"}
+		for _, invite := range sortedInvites(invites) {
+			line := fmt.Sprintf("* %s: %s", lkp.mention(invite.UserID), invite.status())
+			if lkp.inRoom(meeting.Id, invite.UserID) {
+				line += ", in the meeting"
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	userIDs := []string{}
+	for _, param := range params {
+		user, appErr := lkp.API.GetUserByUsername(strings.TrimPrefix(param, "@"))
+		if appErr != nil {
+			return fmt.Sprintf("User %s was not found.", param)
+		}
+		userIDs = append(userIDs, user.Id)
+	}
+	invites, err := lkp.inviteUsers(args.RootId, args.UserId, userIDs)
+	if err != nil {
+		return fmt.Sprintf("Nobody was invited: %s.", err.Error())
+	}
+	return fmt.Sprintf("Invited %d users.", len(invites))
+}
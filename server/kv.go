@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const kvListPageSize = 200
+
+// listKeys returns every key of the plugin which starts with the prefix. The KV store pages
+// through all the keys of the plugin, whatever their prefix, so the pages are read until an
+// empty one. The keys are collected first, so the caller may delete them.
+func (lkp *LiveKitPlugin) listKeys(prefix string) ([]string, error) {
+	keys := []string{}
+	for page := 0; ; page++ {
+		pageKeys, err := lkp.sdk.KV.ListKeys(page, kvListPageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list keys")
+		}
+		if len(pageKeys) == 0 {
+			return keys, nil
+		}
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	kitSDK "github.com/livekit/server-sdk-go"
+	pluginSDK "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+)
+
+// fakeRoomService is an in-memory LiveKit RoomService, served over Twirp by httptest so the
+// plugin talks to it through the real server SDK client.
+type fakeRoomService struct {
+	lock         sync.Mutex
+	rooms        map[string]*livekit.Room
+	participants map[string][]*livekit.ParticipantInfo
+	calls        map[string]int
+	failures     map[string]error
+}
+
+func newFakeRoomService() *fakeRoomService {
+	return &fakeRoomService{
+		rooms:        map[string]*livekit.Room{},
+		participants: map[string][]*livekit.ParticipantInfo{},
+		calls:        map[string]int{},
+		failures:     map[string]error{},
+	}
+}
+
+// fail makes every following call of the method return the error.
+func (f *fakeRoomService) fail(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failures[method] = err
+}
+
+func (f *fakeRoomService) callCount(method string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeRoomService) room(name string) *livekit.Room {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.rooms[name]
+}
+
+// join puts a participant in the room as if they had connected with a token.
+func (f *fakeRoomService) join(roomName, identity string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.participants[roomName] = append(f.participants[roomName], &livekit.ParticipantInfo{
+		Sid:      "PA_" + identity,
+		Identity: identity,
+		Name:     identity,
+		JoinedAt: time.Now().Unix(),
+	})
+}
+
+// call records the call of the method and returns its injected failure, the lock is held
+// until the returned function runs.
+func (f *fakeRoomService) call(method string) (func(), error) {
+	f.lock.Lock()
+	f.calls[method]++
+	return f.lock.Unlock, f.failures[method]
+}
+
+func (f *fakeRoomService) CreateRoom(ctx context.Context, req *livekit.CreateRoomRequest) (*livekit.Room, error) {
+	unlock, err := f.call("CreateRoom")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	room, found := f.rooms[req.Name]
+	if !found {
+		room = &livekit.Room{Sid: "RM_" + req.Name, Name: req.Name, CreationTime: time.Now().Unix(), Metadata: req.Metadata}
+		f.rooms[req.Name] = room
+	}
+	// Like LiveKit, a room which exists only takes the settings above 0.
+	if req.EmptyTimeout > 0 {
+		room.EmptyTimeout = req.EmptyTimeout
+	}
+	if req.MaxParticipants > 0 {
+		room.MaxParticipants = req.MaxParticipants
+	}
+	return room, nil
+}
+
+func (f *fakeRoomService) ListRooms(ctx context.Context, req *livekit.ListRoomsRequest) (*livekit.ListRoomsResponse, error) {
+	unlock, err := f.call("ListRooms")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	response := &livekit.ListRoomsResponse{}
+	for name, room := range f.rooms {
+		wanted := len(req.Names) == 0
+		for _, requested := range req.Names {
+			wanted = wanted || requested == name
+		}
+		if wanted {
+			room.NumParticipants = uint32(len(f.participants[name]))
+			response.Rooms = append(response.Rooms, room)
+		}
+	}
+	return response, nil
+}
+
+func (f *fakeRoomService) DeleteRoom(ctx context.Context, req *livekit.DeleteRoomRequest) (*livekit.DeleteRoomResponse, error) {
+	unlock, err := f.call("DeleteRoom")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	if _, found := f.rooms[req.Room]; !found {
+		return nil, errors.New("room not found")
+	}
+	delete(f.rooms, req.Room)
+	delete(f.participants, req.Room)
+	return &livekit.DeleteRoomResponse{}, nil
+}
+
+func (f *fakeRoomService) ListParticipants(ctx context.Context, req *livekit.ListParticipantsRequest) (*livekit.ListParticipantsResponse, error) {
+	unlock, err := f.call("ListParticipants")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	if _, found := f.rooms[req.Room]; !found {
+		return nil, errors.New("room not found")
+	}
+	return &livekit.ListParticipantsResponse{Participants: f.participants[req.Room]}, nil
+}
+
+func (f *fakeRoomService) GetParticipant(ctx context.Context, req *livekit.RoomParticipantIdentity) (*livekit.ParticipantInfo, error) {
+	unlock, err := f.call("GetParticipant")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, participant := range f.participants[req.Room] {
+		if participant.Identity == req.Identity {
+			return participant, nil
+		}
+	}
+	return nil, errors.New("participant not found")
+}
+
+func (f *fakeRoomService) RemoveParticipant(ctx context.Context, req *livekit.RoomParticipantIdentity) (*livekit.RemoveParticipantResponse, error) {
+	unlock, err := f.call("RemoveParticipant")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	remaining := []*livekit.ParticipantInfo{}
+	for _, participant := range f.participants[req.Room] {
+		if participant.Identity != req.Identity {
+			remaining = append(remaining, participant)
+		}
+	}
+	f.participants[req.Room] = remaining
+	return &livekit.RemoveParticipantResponse{}, nil
+}
+
+func (f *fakeRoomService) MutePublishedTrack(ctx context.Context, req *livekit.MuteRoomTrackRequest) (*livekit.MuteRoomTrackResponse, error) {
+	unlock, err := f.call("MutePublishedTrack")
+	defer unlock()
+	return &livekit.MuteRoomTrackResponse{}, err
+}
+
+func (f *fakeRoomService) UpdateParticipant(ctx context.Context, req *livekit.UpdateParticipantRequest) (*livekit.ParticipantInfo, error) {
+	unlock, err := f.call("UpdateParticipant")
+	defer unlock()
+	return &livekit.ParticipantInfo{Identity: req.Identity, Metadata: req.Metadata}, err
+}
+
+func (f *fakeRoomService) UpdateSubscriptions(ctx context.Context, req *livekit.UpdateSubscriptionsRequest) (*livekit.UpdateSubscriptionsResponse, error) {
+	unlock, err := f.call("UpdateSubscriptions")
+	defer unlock()
+	return &livekit.UpdateSubscriptionsResponse{}, err
+}
+
+func (f *fakeRoomService) SendData(ctx context.Context, req *livekit.SendDataRequest) (*livekit.SendDataResponse, error) {
+	unlock, err := f.call("SendData")
+	defer unlock()
+	return &livekit.SendDataResponse{}, err
+}
+
+func (f *fakeRoomService) UpdateRoomMetadata(ctx context.Context, req *livekit.UpdateRoomMetadataRequest) (*livekit.Room, error) {
+	unlock, err := f.call("UpdateRoomMetadata")
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	room, found := f.rooms[req.Room]
+	if !found {
+		return nil, errors.New("room not found")
+	}
+	room.Metadata = req.Metadata
+	return room, nil
+}
+
+// testAPI drops the log calls, which plugintest would otherwise require to be mocked one
+// by one, including those of goroutines such as the chat bridge. It also keeps the KV store
+// in memory, so the state saved by the plugin can be checked.
+type testAPI struct {
+	*plugintest.API
+	kvLock sync.Mutex
+	kv     map[string][]byte
+}
+
+func (a *testAPI) LogDebug(msg string, keyValuePairs ...interface{}) {}
+func (a *testAPI) LogInfo(msg string, keyValuePairs ...interface{})  {}
+func (a *testAPI) LogWarn(msg string, keyValuePairs ...interface{})  {}
+func (a *testAPI) LogError(msg string, keyValuePairs ...interface{}) {}
+
+func (a *testAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.kvLock.Lock()
+	defer a.kvLock.Unlock()
+	return a.kv[key], nil
+}
+
+func (a *testAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.kvLock.Lock()
+	defer a.kvLock.Unlock()
+	if options.Atomic && !bytes.Equal(a.kv[key], options.OldValue) {
+		return false, nil
+	}
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *testAPI) KVDelete(key string) *model.AppError {
+	a.kvLock.Lock()
+	defer a.kvLock.Unlock()
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *testAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.kvLock.Lock()
+	defer a.kvLock.Unlock()
+	keys := []string{}
+	for key := range a.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if page*perPage >= len(keys) {
+		return []string{}, nil
+	}
+	end := page*perPage + perPage
+	if end > len(keys) {
+		end = len(keys)
+	}
+	return keys[page*perPage : end], nil
+}
+
+// newTestPlugin wires the plugin to a plugintest API mock and a fake LiveKit server. The
+// bridge connects to a closed port, so it gives up at once.
+func newTestPlugin(t *testing.T) (*LiveKitPlugin, *testAPI, *fakeRoomService) {
+	fake := newFakeRoomService()
+	server := httptest.NewServer(livekit.NewRoomServiceServer(fake))
+	t.Cleanup(server.Close)
+
+	api := &testAPI{API: &plugintest.API{}, kv: map[string][]byte{}}
+	lkp := &LiveKitPlugin{
+		botUserID: "botuserid",
+		configuration: &configuration{
+			Host:     "127.0.0.1",
+			Port:     1,
+			ApiKey:   "devkey",
+			ApiValue: "devsecret",
+		},
+		bridges: map[string]*kitSDK.Room{},
+	}
+	lkp.SetAPI(api)
+	lkp.sdk = pluginSDK.NewClient(lkp.API, nil)
+	lkp.master = kitSDK.NewRoomServiceClient(server.URL, "devkey", "devsecret")
+	return lkp, api, fake
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// meetingLocked tells whether the host has closed the meeting to new joiners.
+func meetingLocked(meeting *model.Post) bool {
+	locked, _ := meeting.GetProp("room_locked").(bool)
+	return locked
+}
+
+// canManageMeeting tells whether the user may lock the meeting: its host, the channel
+// admins and the system admins.
+func (lkp *LiveKitPlugin) canManageMeeting(meeting *model.Post, userID string) bool {
+	return lkp.isHost(meeting, userID) || lkp.isChannelAdmin(meeting.ChannelId, userID)
+}
+
+// inRoom tells whether the user is connected to the room right now.
+func (lkp *LiveKitPlugin) inRoom(roomName, userID string) bool {
+	_, err := lkp.master.GetParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: roomName, Identity: userID})
+	return err == nil
+}
+
+// checkLock refuses new joiners while the meeting is locked. Participants who are already
+// in the room keep refreshing their tokens, the managers of the meeting and the users they
+// invited always get in.
+func (lkp *LiveKitPlugin) checkLock(meeting *model.Post, userID string) error {
+	if !meetingLocked(meeting) || lkp.canManageMeeting(meeting, userID) || lkp.inRoom(meeting.Id, userID) || lkp.isInvited(meeting.Id, userID) {
+		return nil
+	}
+	return newStatusError(http.StatusLocked, "meeting is locked, ask the host to let you in")
+}
+
+// lockMeeting saves the lock state of the meeting and announces it in the thread.
+func (lkp *LiveKitPlugin) lockMeeting(meetingID, userID string, locked bool) (meeting *model.Post, err error) {
+	event := auditEvent{Action: auditMeetingLock, ActorID: userID, MeetingID: meetingID}
+	if !locked {
+		event.Action = auditMeetingUnlock
+	}
+	defer func() { lkp.audit(event, err) }()
+	meeting, err = lkp.getMeeting(meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if meetingEnded(meeting) {
+		return nil, newStatusError(http.StatusGone, "meeting has ended")
+	}
+	if !lkp.canManageMeeting(meeting, userID) {
+		return nil, newStatusError(http.StatusForbidden, "only the host and admins can lock the meeting")
+	}
+	if meetingLocked(meeting) == locked {
+		return meeting, nil
+	}
+	meeting.AddProp("room_locked", locked)
+	model.ParseSlackAttachment(meeting, lkp.meetingAttachments(meeting))
+	updated, appErr := lkp.API.UpdatePost(meeting)
+	if appErr != nil {
+		return nil, errors.Wrap(appError(appErr), "failed to update meeting post")
+	}
+	who := "The host"
+	if user, appErr := lkp.API.GetUser(userID); appErr == nil {
+		who = "@" + user.Username
+	}
+	if locked {
+		lkp.threadReply(updated, fmt.Sprintf("%s locked the meeting, nobody else can join.", who))
+	} else {
+		lkp.threadReply(updated, fmt.Sprintf("%s unlocked the meeting.", who))
+	}
+	lkp.API.LogInfo("meeting lock changed", "meeting", updated.Id, "user_id", userID, "locked", locked)
+	return updated, nil
+}
+
+// executeLock handles "/liveroom lock" and "/liveroom unlock" in the meeting thread.
+func (lkp *LiveKitPlugin) executeLock(args *model.CommandArgs, locked bool) string {
+	if args.RootId == "" {
+		return "Run this command in the meeting thread."
+	}
+	if _, err := lkp.lockMeeting(args.RootId, args.UserId, locked); err != nil {
+		return fmt.Sprintf("The lock was not changed: %s.", err.Error())
+	}
+	if locked {
+		return "Meeting locked."
+	}
+	return "Meeting unlocked."
+}
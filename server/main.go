@@ -3,18 +3,24 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	kitSDK "github.com/livekit/server-sdk-go"
 	pluginSDK "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin"
 	"github.com/pkg/errors"
 )
 
+// pluginID must match the id in plugin.json.
+const pluginID = "com.mattermost.plugin-livekit"
+
 // LiveKitPlugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
 type LiveKitPlugin struct {
 	plugin.MattermostPlugin
@@ -24,6 +30,14 @@ type LiveKitPlugin struct {
 	configuration     *configuration
 	master            *kitSDK.RoomServiceClient
 	sdk               *pluginSDK.Client
+	bridgeLock        sync.Mutex
+	bridges           map[string]*kitSDK.Room
+	scheduler         *cluster.JobOnceScheduler
+	retentionJob      *cluster.Job
+	reconcileJob      *cluster.Job
+	auditJob          *cluster.Job
+	routerOnce        sync.Once
+	router            http.Handler
 }
 
 func main() {
@@ -36,6 +50,7 @@ func (lkp *LiveKitPlugin) OnActivate() error {
 	// validate configuration here
 	lkp.configuration = configuration
 	lkp.sdk = pluginSDK.NewClient(lkp.API, lkp.Driver)
+	lkp.bridges = map[string]*kitSDK.Room{}
 
 	//Bot
 	liveBot := &model.Bot{
@@ -77,6 +92,28 @@ func (lkp *LiveKitPlugin) OnActivate() error {
 			lkp.API.LogInfo("slash command registered")
 			serverURL := fmt.Sprintf("https://%s:%d", lkp.configuration.Host, lkp.configuration.Port)
 			lkp.master = kitSDK.NewRoomServiceClient(serverURL, lkp.configuration.ApiKey, lkp.configuration.ApiValue)
+			lkp.API.LogInfo("Starting job scheduler")
+			lkp.scheduler = cluster.GetJobOnceScheduler(lkp.API)
+			err = lkp.scheduler.SetCallback(lkp.runScheduledJob)
+			if err == nil {
+				err = lkp.scheduler.Start()
+			}
+			if err != nil {
+				return errors.Wrap(err, "couldn't start job scheduler")
+			}
+			lkp.retentionJob, err = cluster.Schedule(lkp.API, "recording_retention", cluster.MakeWaitForRoundedInterval(time.Hour), lkp.cleanupRecordings)
+			if err != nil {
+				return errors.Wrap(err, "couldn't schedule recording retention")
+			}
+			lkp.reconcileJob, err = cluster.Schedule(lkp.API, "reconcile_rooms", cluster.MakeWaitForInterval(5*time.Minute), lkp.reconcileRooms)
+			if err != nil {
+				return errors.Wrap(err, "couldn't schedule room reconciliation")
+			}
+			lkp.auditJob, err = cluster.Schedule(lkp.API, "audit_retention", cluster.MakeWaitForRoundedInterval(24*time.Hour), lkp.cleanupAudit)
+			if err != nil {
+				return errors.Wrap(err, "couldn't schedule audit retention")
+			}
+			go lkp.reconcileOnce()
 			lkp.API.LogInfo("LiveKit integration activated")
 			return nil
 		}
@@ -86,12 +123,76 @@ func (lkp *LiveKitPlugin) OnActivate() error {
 }
 
 func (lkp *LiveKitPlugin) OnDeactivate() error {
+	lkp.closeBridges()
+	if lkp.retentionJob != nil {
+		if err := lkp.retentionJob.Close(); err != nil {
+			lkp.API.LogError("retention job not stopped", "reason", err.Error())
+		}
+	}
+	if lkp.reconcileJob != nil {
+		if err := lkp.reconcileJob.Close(); err != nil {
+			lkp.API.LogError("reconciliation job not stopped", "reason", err.Error())
+		}
+	}
+	if lkp.auditJob != nil {
+		if err := lkp.auditJob.Close(); err != nil {
+			lkp.API.LogError("audit retention job not stopped", "reason", err.Error())
+		}
+	}
 	return nil
 }
 
+// runScheduledJob is called by the cluster scheduler with the key of the job that is due.
+func (lkp *LiveKitPlugin) runScheduledJob(key string) {
+	switch {
+	case strings.HasPrefix(key, breakoutJobPrefix):
+		meetingID := strings.TrimPrefix(key, breakoutJobPrefix)
+		if err := lkp.endBreakout(meetingID); err != nil {
+			lkp.API.LogError("breakout end failed", "meeting", meetingID, "reason", err.Error())
+		}
+	default:
+		lkp.API.LogWarn("unknown scheduled job", "key", key)
+	}
+}
+
 func (lkp *LiveKitPlugin) compileSlashCommand() (*model.Command, error) {
 	// https://developers.mattermost.com/integrate/admin-guide/admin-slash-commands/
-	acData := model.NewAutocompleteData("liveroom", "[topic]", "Start a LiveKit meeting in current channel. Topic should be provided in double quotes.")
+	acData := model.NewAutocompleteData("liveroom", "[topic] [capacity]", "Start a LiveKit meeting in current channel. Topic should be provided in double quotes.")
+	breakout := model.NewAutocompleteData("breakout", "[rooms] [minutes] | end", "Split the meeting participants into breakout rooms. Run it in the meeting thread.")
+	acData.AddCommand(breakout)
+	retention := model.NewAutocompleteData("retention", "[days] | forever | hold | default", "Show or change how long the recordings of this channel are kept. Legal holds and shorter retention need a system admin.")
+	acData.AddCommand(retention)
+	schedule := model.NewAutocompleteData("schedule", "[topic] YYYY-MM-DD HH:MM [minutes]", "Schedule a meeting in current channel. Topic should be provided in double quotes.")
+	acData.AddCommand(schedule)
+	calendar := model.NewAutocompleteData("calendar", "[reset]", "Get the address of your personal calendar feed of scheduled meetings.")
+	acData.AddCommand(calendar)
+	status := model.NewAutocompleteData("status", "on|off", "Choose whether your status shows the meeting you are in.")
+	status.AddStaticListArgument("", true, []model.AutocompleteListItem{{Item: "on"}, {Item: "off"}})
+	acData.AddCommand(status)
+	notify := model.NewAutocompleteData("notify", "on|off", "Get a direct message when a meeting starts in this channel.")
+	notify.AddStaticListArgument("", true, []model.AutocompleteListItem{{Item: "on"}, {Item: "off"}})
+	acData.AddCommand(notify)
+	edit := model.NewAutocompleteData("edit", "topic [topic] | capacity N | overflow none|queue|listen", "Change the meeting settings. Run it in the meeting thread.")
+	acData.AddCommand(edit)
+	cohost := model.NewAutocompleteData("cohost", "@user [remove]", "Let another user manage the meeting. Run it in the meeting thread.")
+	acData.AddCommand(cohost)
+	host := model.NewAutocompleteData("host", "@user", "Hand the host role to another user. Run it in the meeting thread.")
+	acData.AddCommand(host)
+	end := model.NewAutocompleteData("end", "", "End the meeting for everyone. Run it in the meeting thread.")
+	acData.AddCommand(end)
+	lock := model.NewAutocompleteData("lock", "", "Stop new participants from joining the meeting. Run it in the meeting thread.")
+	acData.AddCommand(lock)
+	unlock := model.NewAutocompleteData("unlock", "", "Let new participants join the meeting again. Run it in the meeting thread.")
+	acData.AddCommand(unlock)
+	join := model.NewAutocompleteData("join", "[code]", "Join a meeting by its code, such as kqz-mtwp-rda.")
+	acData.AddCommand(join)
+	invite := model.NewAutocompleteData("invite", "[@user ...]", "Invite users to the meeting, even from outside the channel, or list the invitations. Run it in the meeting thread.")
+	acData.AddCommand(invite)
+	history := model.NewAutocompleteData("history", "[days | YYYY-MM-DD [YYYY-MM-DD]] [page N]", "List the meetings you created or joined, the last week by default.")
+	acData.AddCommand(history)
+	audit := model.NewAutocompleteData("audit", "[days] [@user|action] [all]", "Show the audit log of this meeting or channel, or of all channels. System admins only.")
+	audit.RoleID = model.SystemAdminRoleId
+	acData.AddCommand(audit)
 	// start := model.NewAutocompleteData("start", "[topic]", "Start a new meeting in the current channel")
 	// start.AddTextArgument("(optional) The topic of the new meeting", "[topic]", "")
 	// acData.AddCommand(start)
@@ -109,15 +210,65 @@ func (lkp *LiveKitPlugin) compileSlashCommand() (*model.Command, error) {
 
 func (lkp *LiveKitPlugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	response := &model.CommandResponse{ResponseType: model.CommandResponseTypeEphemeral}
-	// fields := strings.Fields(args.Command)
+	fields := strings.Fields(args.Command)
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "breakout":
+			response.Text = lkp.executeBreakout(args, fields[2:])
+			return response, nil
+		case "retention":
+			response.Text = lkp.executeRetention(args, fields[2:])
+			return response, nil
+		case "schedule":
+			response.Text = lkp.executeSchedule(args)
+			return response, nil
+		case "calendar":
+			response.Text = lkp.executeCalendar(args, fields[2:])
+			return response, nil
+		case "status":
+			response.Text = lkp.executeStatus(args, fields[2:])
+			return response, nil
+		case "notify":
+			response.Text = lkp.executeNotify(args, fields[2:])
+			return response, nil
+		case "edit":
+			response.Text = lkp.executeEdit(args, fields[2:])
+			return response, nil
+		case "cohost", "host":
+			response.Text = lkp.executeHosts(args, fields[1], fields[2:])
+			return response, nil
+		case "end":
+			response.Text = lkp.executeEnd(args)
+			return response, nil
+		case "lock", "unlock":
+			response.Text = lkp.executeLock(args, fields[1] == "lock")
+			return response, nil
+		case "join":
+			response.Text = lkp.executeJoin(args, fields[2:])
+			return response, nil
+		case "invite":
+			response.Text = lkp.executeInvite(args, fields[2:])
+			return response, nil
+		case "history":
+			response.Text = lkp.executeHistory(args, fields[2:])
+			return response, nil
+		case "audit":
+			response.Text = lkp.executeAudit(args, fields[2:])
+			return response, nil
+		}
+	}
 
 	splitted := strings.Split(args.Command, "\"")
-	if len(splitted) > 1 && splitted[0] == "/liveroom " {
+	if len(splitted) == 3 && splitted[0] == "/liveroom " {
+		if err := lkp.canCreateMeeting(args.ChannelId, args.UserId); err != nil {
+			response.Text = err.Error()
+			return response, nil
+		}
 		maxParticipants := uint32(0)
+		options := meetingOptions{}
 		topic := splitted[1]
-		n := strings.ReplaceAll(splitted[2], " ", "")
-		if len(splitted) == 3 && len(n) > 0 {
-			integer, err := strconv.Atoi(n)
+		for _, param := range strings.Fields(splitted[2]) {
+			integer, err := strconv.Atoi(param)
 			if err != nil {
 				response.Text = err.Error()
 				return response, nil
@@ -125,9 +276,9 @@ func (lkp *LiveKitPlugin) ExecuteCommand(c *plugin.Context, args *model.CommandA
 			maxParticipants = uint32(integer)
 		}
 		lkp.API.LogInfo("creating rom", "topic", topic, "n", maxParticipants)
-		appErr := lkp.createPost(args.ChannelId, args.UserId, topic, maxParticipants)
+		meeting, appErr := lkp.createPost(args.ChannelId, args.UserId, topic, maxParticipants, options)
 		if appErr == nil {
-			response.Text = fmt.Sprintf("Creating room with topic = %s; maxParticipants = %d", topic, maxParticipants)
+			response.Text = fmt.Sprintf("Creating room with topic = %s; maxParticipants = %d; code = %s", topic, maxParticipants, codeOf(meeting))
 		} else {
 			response.Text = fmt.Sprintf("Room creation failed: %s", appErr.DetailedError)
 		}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed templates/meet.html
+var templateFiles embed.FS
+
+var meetTemplate = template.Must(template.ParseFS(templateFiles, "templates/meet.html"))
+
+// serveMeetingPage renders a standalone LiveKit client for /meet/<postID> or /meet/<code>, so
+// the meeting can be joined from any browser where the user is logged into Mattermost.
+func (lkp *LiveKitPlugin) serveMeetingPage(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	if userID == "" {
+		redirect := fmt.Sprintf("/plugins/%s/meet/%s", pluginID, mux.Vars(r)["id"])
+		http.Redirect(w, r, lkp.siteURL()+"/login?redirect_to="+url.QueryEscape(redirect), http.StatusFound)
+		return
+	}
+	postID, err := lkp.meetingIDOf(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	jwt, err := lkp.joinMeeting(postID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	meeting, err := lkp.getMeeting(postID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	configuration := lkp.getConfiguration()
+	page := struct {
+		Topic        string
+		ClientURL    string
+		ServerURL    string
+		Token        string
+		PostID       string
+		RefreshURL   string
+		BreakoutURL  string
+		PermalinkURL string
+	}{
+		Topic:        meeting.Message,
+		ClientURL:    fmt.Sprintf("/plugins/%s/public/livekit-client.umd.js", pluginID),
+		ServerURL:    fmt.Sprintf("wss://%s:%d", configuration.Host, configuration.Port),
+		Token:        jwt,
+		PostID:       meeting.Id,
+		RefreshURL:   fmt.Sprintf("/plugins/%s/api/v1/meetings/%s/refresh", pluginID, meeting.Id),
+		BreakoutURL:  fmt.Sprintf("/plugins/%s/api/v1/meetings/%s/breakout/token", pluginID, meeting.Id),
+		PermalinkURL: fmt.Sprintf("%s/_redirect/pl/%s", lkp.siteURL(), meeting.Id),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := meetTemplate.Execute(w, page); err != nil {
+		lkp.API.LogError("meeting page not rendered", "meeting", meeting.Id, "reason", err.Error())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+	"github.com/pkg/errors"
+)
+
+// roomMetadataVersion is raised whenever a field of roomMetadata changes meaning, so that
+// clients can tell which layout they are reading.
+const roomMetadataVersion = 1
+
+// roomMetadata is the JSON document kept in the metadata of a meeting room. It gives
+// LiveKit clients and webhook consumers the meeting context without asking Mattermost.
+type roomMetadata struct {
+	Version   int            `json:"version"`
+	MeetingID string         `json:"meeting_id"`
+	Topic     string         `json:"topic"`
+	ChannelID string         `json:"channel_id"`
+	TeamID    string         `json:"team_id"`
+	HostID    string         `json:"host_id"`
+	CoHosts   []string       `json:"co_hosts"`
+	Capacity  uint32         `json:"capacity"`
+	Options   meetingOptions `json:"options"`
+	Locked    bool           `json:"locked"`
+}
+
+// numberProp reads a numeric prop, which is a float64 once the post went through JSON.
+func numberProp(post *model.Post, key string) int64 {
+	switch value := post.GetProp(key).(type) {
+	case float64:
+		return int64(value)
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case uint32:
+		return int64(value)
+	}
+	return 0
+}
+
+// stringsProp reads a list of strings, which is a []interface{} once the post went through JSON.
+func stringsProp(post *model.Post, key string) []string {
+	values := []string{}
+	switch list := post.GetProp(key).(type) {
+	case []string:
+		values = append(values, list...)
+	case []interface{}:
+		for _, item := range list {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// optionsOf reads back the options the meeting was created with.
+func optionsOf(meeting *model.Post) meetingOptions {
+	return meetingOptions{
+		StartAt:  numberProp(meeting, "room_start"),
+		Duration: int(numberProp(meeting, "room_duration")),
+		Overflow: overflowOf(meeting),
+	}
+}
+
+// roomMetadata renders the metadata document of the meeting.
+func (lkp *LiveKitPlugin) roomMetadata(meeting *model.Post) (string, error) {
+	metadata := roomMetadata{
+		Version:   roomMetadataVersion,
+		MeetingID: meeting.Id,
+		Topic:     meeting.Message,
+		ChannelID: meeting.ChannelId,
+		HostID:    hostOf(meeting),
+		CoHosts:   coHostsOf(meeting),
+		Capacity:  uint32(numberProp(meeting, "room_capacity")),
+		Options:   optionsOf(meeting),
+		Locked:    meetingLocked(meeting),
+	}
+	if channel, appErr := lkp.API.GetChannel(meeting.ChannelId); appErr == nil {
+		metadata.TeamID = channel.TeamId
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode room metadata")
+	}
+	return string(data), nil
+}
+
+// syncRoomMetadata pushes the metadata of the meeting to its room, if the room is open.
+func (lkp *LiveKitPlugin) syncRoomMetadata(meeting *model.Post) error {
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{Names: []string{meeting.Id}})
+	if err != nil {
+		return errors.Wrap(err, "failed to look up room")
+	}
+	if len(roomList.Rooms) == 0 {
+		return nil
+	}
+	metadata, err := lkp.roomMetadata(meeting)
+	if err != nil {
+		return err
+	}
+	if roomList.Rooms[0].Metadata == metadata {
+		return nil
+	}
+	if _, err := lkp.master.UpdateRoomMetadata(context.Background(), &livekit.UpdateRoomMetadataRequest{Room: meeting.Id, Metadata: metadata}); err != nil {
+		return errors.Wrap(err, "failed to update room metadata")
+	}
+	lkp.API.LogDebug("room metadata updated", "room", meeting.Id)
+	return nil
+}
+
+// MessageHasBeenUpdated keeps the room metadata in step with the meeting post.
+func (lkp *LiveKitPlugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	if newPost.Type != "custom_livekit" || meetingEnded(newPost) {
+		return
+	}
+	if err := lkp.syncRoomMetadata(newPost); err != nil {
+		lkp.API.LogWarn("room metadata not synced", "room", newPost.Id, "reason", err.Error())
+	}
+}
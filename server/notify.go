@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	notifyKeyPrefix = "notify_"
+	// roomStarterKeyPrefix keeps the user whose join created the room until it has started.
+	roomStarterKeyPrefix = "room_starter_"
+)
+
+// getSubscribers returns the users who want to hear about meetings starting in the channel.
+func (lkp *LiveKitPlugin) getSubscribers(channelID string) ([]string, error) {
+	subscribers := []string{}
+	err := lkp.sdk.KV.Get(notifyKeyPrefix+channelID, &subscribers)
+	return subscribers, err
+}
+
+// subscribe adds or removes the user from the subscribers of the channel.
+func (lkp *LiveKitPlugin) subscribe(channelID, userID string, on bool) error {
+	return lkp.sdk.KV.SetAtomicWithRetries(notifyKeyPrefix+channelID, func(oldValue []byte) (interface{}, error) {
+		subscribers := []string{}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &subscribers); err != nil {
+				return nil, err
+			}
+		}
+		updated := []string{}
+		for _, subscriber := range subscribers {
+			if subscriber != userID {
+				updated = append(updated, subscriber)
+			}
+		}
+		if on {
+			updated = append(updated, userID)
+		}
+		return updated, nil
+	})
+}
+
+// onRoomStarted sends a direct message to every subscriber of the channel who is not in
+// the room yet, leaving out the user whose join started it. The server does not push direct
+// messages to users in Do Not Disturb, so they only find the message when they come back.
+func (lkp *LiveKitPlugin) onRoomStarted(room *livekit.Room) {
+	if room == nil || meetingOfRoom(room.Name) != room.Name {
+		return
+	}
+	var starterID string
+	if err := lkp.sdk.KV.Get(roomStarterKeyPrefix+room.Name, &starterID); err != nil {
+		lkp.API.LogWarn("room starter not read", "room", room.Name, "reason", err.Error())
+	}
+	if starterID != "" {
+		if err := lkp.sdk.KV.Delete(roomStarterKeyPrefix + room.Name); err != nil {
+			lkp.API.LogWarn("room starter not deleted", "room", room.Name, "reason", err.Error())
+		}
+	}
+	meeting, err := lkp.getMeeting(room.Name)
+	if err != nil {
+		return
+	}
+	subscribers, err := lkp.getSubscribers(meeting.ChannelId)
+	if err != nil {
+		lkp.API.LogError("subscribers not read", "channel", meeting.ChannelId, "reason", err.Error())
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+	present := map[string]bool{starterID: true}
+	if participantList, err := lkp.master.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: room.Name}); err == nil {
+		for _, participant := range participantList.Participants {
+			present[participant.Identity] = true
+		}
+	}
+	channel, appErr := lkp.API.GetChannel(meeting.ChannelId)
+	if appErr != nil {
+		return
+	}
+	message := fmt.Sprintf("A meeting has started in **%s**: %s\n[Join the meeting](%s)", channel.DisplayName, meeting.Message, lkp.joinLink(meeting.Id))
+	for _, userID := range subscribers {
+		if present[userID] || userID == lkp.botUserID {
+			continue
+		}
+		if _, appErr := lkp.API.GetChannelMember(meeting.ChannelId, userID); appErr != nil {
+			continue
+		}
+		if err := lkp.directMessage(userID, message); err != nil {
+			lkp.API.LogWarn("meeting notification not sent", "user_id", userID, "reason", err.Error())
+		}
+	}
+	lkp.API.LogInfo("meeting start notified", "meeting", meeting.Id, "subscribers", len(subscribers))
+}
+
+// directMessage posts the message to the direct channel between the bot and the user.
+func (lkp *LiveKitPlugin) directMessage(userID, message string) error {
+	channel, appErr := lkp.API.GetDirectChannel(userID, lkp.botUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get direct channel")
+	}
+	_, appErr = lkp.API.CreatePost(&model.Post{UserId: lkp.botUserID, ChannelId: channel.Id, Message: message})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to post direct message")
+	}
+	return nil
+}
+
+// executeNotify handles "/liveroom notify on|off" in the current channel.
+func (lkp *LiveKitPlugin) executeNotify(args *model.CommandArgs, params []string) string {
+	if len(params) != 1 || (params[0] != "on" && params[0] != "off") {
+		subscribers, err := lkp.getSubscribers(args.ChannelId)
+		if err != nil {
+			return err.Error()
+		}
+		for _, subscriber := range subscribers {
+			if subscriber == args.UserId {
+				return "You are notified about meetings in this channel. Usage: /liveroom notify on|off"
+			}
+		}
+		return "You are not notified about meetings in this channel. Usage: /liveroom notify on|off"
+	}
+	on := params[0] == "on"
+	if err := lkp.subscribe(args.ChannelId, args.UserId, on); err != nil {
+		return errors.Wrap(err, "failed to save the subscription").Error()
+	}
+	if on {
+		return "You will get a message when a meeting starts in this channel."
+	}
+	return "You won't get messages about meetings in this channel anymore."
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// meetingNotAllowedError explains why a meeting can't be started in a channel. The slash
+// command and the HTTP API show the same text.
+type meetingNotAllowedError struct {
+	reason string
+}
+
+func (e *meetingNotAllowedError) Error() string {
+	return fmt.Sprintf("You can't start a meeting in this channel: %s.", e.reason)
+}
+
+func (e *meetingNotAllowedError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// canCreateMeeting applies the same rules the server applies to posting: the channel must
+// not be archived and the user needs the create_post permission there. Read-only channels
+// are covered by the permission, as the channel moderation takes it away from members.
+func (lkp *LiveKitPlugin) canCreateMeeting(channelID, userID string) error {
+	channel, appErr := lkp.API.GetChannel(channelID)
+	if appErr != nil {
+		return &meetingNotAllowedError{"the channel was not found"}
+	}
+	if channel.DeleteAt != 0 {
+		return &meetingNotAllowedError{"the channel is archived"}
+	}
+	if !lkp.API.HasPermissionToChannel(userID, channelID, model.PermissionCreatePost) {
+		return &meetingNotAllowedError{"you are not allowed to post here"}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// liveKeyPrefix indexes the meetings whose post says that their room is open.
+const liveKeyPrefix = "live_"
+
+func meetingLive(meeting *model.Post) bool {
+	live, _ := meeting.GetProp("room_live").(bool)
+	return live
+}
+
+// setLive records on the post whether the meeting room is open. It reports whether the
+// post had to change. The post is read again first, since the webhooks race with the other
+// changes of the meeting, and an ended meeting is never marked live.
+func (lkp *LiveKitPlugin) setLive(meeting *model.Post, live bool) (bool, error) {
+	latest, err := lkp.getMeeting(meeting.Id)
+	if err != nil {
+		return false, err
+	}
+	live = live && !meetingEnded(latest)
+	if live {
+		if _, err := lkp.sdk.KV.Set(liveKeyPrefix+meeting.Id, true); err != nil {
+			return false, errors.Wrap(err, "failed to index live meeting")
+		}
+	} else if err := lkp.sdk.KV.Delete(liveKeyPrefix + meeting.Id); err != nil {
+		return false, errors.Wrap(err, "failed to unindex live meeting")
+	}
+	if meetingLive(latest) == live {
+		return false, nil
+	}
+	latest.AddProp("room_live", live)
+	if _, appErr := lkp.API.UpdatePost(latest); appErr != nil {
+		return false, errors.Wrap(appErr, "failed to update meeting post")
+	}
+	return true, nil
+}
+
+// onRoomLive follows the room_started and room_finished webhooks of the meeting rooms.
+func (lkp *LiveKitPlugin) onRoomLive(room *livekit.Room, live bool) {
+	if room == nil || meetingOfRoom(room.Name) != room.Name {
+		return
+	}
+	meeting, err := lkp.getMeeting(room.Name)
+	if err != nil || meetingEnded(meeting) {
+		return
+	}
+	if _, err := lkp.setLive(meeting, live); err != nil {
+		lkp.API.LogError("meeting status not saved", "meeting", meeting.Id, "reason", err.Error())
+	}
+}
+
+// pluginRoom tells whether the room was opened by this plugin and for which meeting. Meeting
+// rooms carry roomMetadata, breakout rooms the id of their meeting.
+func pluginRoom(room *livekit.Room) (string, bool) {
+	if meetingID := meetingOfRoom(room.Name); meetingID != room.Name {
+		return meetingID, room.Metadata == meetingID
+	}
+	var metadata roomMetadata
+	if err := json.Unmarshal([]byte(room.Metadata), &metadata); err != nil || metadata.Version == 0 {
+		return "", false
+	}
+	return metadata.MeetingID, metadata.MeetingID == room.Name
+}
+
+// reconcileRooms repairs what missed webhooks and downtime left behind: rooms of deleted or
+// ended meetings are closed, and the live status of the posts is set from the open rooms.
+func (lkp *LiveKitPlugin) reconcileRooms() {
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
+	if err != nil {
+		lkp.API.LogError("rooms not reconciled", "reason", err.Error())
+		return
+	}
+	open := map[string]bool{}
+	for _, room := range roomList.Rooms {
+		meetingID, ours := pluginRoom(room)
+		if !ours {
+			continue
+		}
+		meeting, err := lkp.getMeeting(meetingID)
+		reason := ""
+		switch {
+		case errorStatus(err) == http.StatusNotFound:
+			reason = "meeting post is gone"
+		case err != nil:
+			lkp.API.LogWarn("room not reconciled", "room", room.Name, "reason", err.Error())
+			open[room.Name] = true
+			continue
+		case meetingEnded(meeting):
+			reason = "meeting has ended"
+		case room.Name != meetingID:
+			if session, err := lkp.getBreakout(meetingID); err == nil && session == nil {
+				reason = "breakout is over"
+			}
+		}
+		if reason != "" {
+			if _, err := lkp.master.DeleteRoom(context.Background(), &livekit.DeleteRoomRequest{Room: room.Name}); err != nil {
+				lkp.API.LogWarn("orphaned room not closed", "room", room.Name, "reason", err.Error())
+			} else {
+				lkp.API.LogInfo("orphaned room closed", "room", room.Name, "reason", reason)
+			}
+			continue
+		}
+		open[room.Name] = true
+		if room.Name != meetingID {
+			continue
+		}
+		if changed, err := lkp.setLive(meeting, true); err != nil {
+			lkp.API.LogError("meeting status not fixed", "meeting", meeting.Id, "reason", err.Error())
+		} else if changed {
+			lkp.API.LogInfo("meeting marked live", "meeting", meeting.Id)
+		}
+	}
+
+	keys, err := lkp.listKeys(liveKeyPrefix)
+	if err != nil {
+		lkp.API.LogError("live meetings not listed", "reason", err.Error())
+		return
+	}
+	for _, key := range keys {
+		meetingID := strings.TrimPrefix(key, liveKeyPrefix)
+		if open[meetingID] {
+			continue
+		}
+		meeting, err := lkp.getMeeting(meetingID)
+		if err != nil {
+			if errorStatus(err) != http.StatusNotFound {
+				lkp.API.LogWarn("live meeting not reconciled", "meeting", meetingID, "reason", err.Error())
+				continue
+			}
+			if err := lkp.sdk.KV.Delete(key); err != nil {
+				lkp.API.LogWarn("live meeting not unindexed", "meeting", meetingID, "reason", err.Error())
+			}
+			continue
+		}
+		if changed, err := lkp.setLive(meeting, false); err != nil {
+			lkp.API.LogError("meeting status not fixed", "meeting", meeting.Id, "reason", err.Error())
+		} else if changed {
+			lkp.API.LogInfo("meeting marked closed", "meeting", meeting.Id)
+		}
+	}
+}
+
+// reconcileOnce runs the reconciliation at most once at a time in the cluster, for the
+// repair after the plugin was down. The scheduled job is already exclusive.
+func (lkp *LiveKitPlugin) reconcileOnce() {
+	mutex, err := cluster.NewMutex(lkp.API, "reconcile_rooms_lock")
+	if err != nil {
+		lkp.API.LogError("rooms not reconciled", "reason", err.Error())
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	lkp.reconcileRooms()
+}
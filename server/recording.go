@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const recordingKeyPrefix = "recording_"
+
+// recording is a finished LiveKit egress of a meeting, together with everything the plugin
+// posted about it.
+type recording struct {
+	EgressID  string   `json:"egress_id"`
+	MeetingID string   `json:"meeting_id"`
+	ChannelID string   `json:"channel_id"`
+	Filename  string   `json:"filename"`
+	Location  string   `json:"location"`
+	Size      int64    `json:"size"`
+	EndedAt   int64    `json:"ended_at"`
+	CreateAt  int64    `json:"create_at"`
+	PostIDs   []string `json:"post_ids"`
+	// DeleteFailedAt is set when the expired recording could not be deleted, so the failure
+	// is audited once while the deletion is retried.
+	DeleteFailedAt int64 `json:"delete_failed_at,omitempty"`
+}
+
+func (lkp *LiveKitPlugin) saveRecording(record *recording) error {
+	_, err := lkp.sdk.KV.Set(recordingKeyPrefix+record.EgressID, record)
+	return errors.Wrap(err, "failed to save recording")
+}
+
+// meetingOfRoom returns the id of the meeting post a room belongs to, breakout rooms included.
+func meetingOfRoom(roomName string) string {
+	return strings.SplitN(roomName, "-breakout-", 2)[0]
+}
+
+// onEgressEnded links a finished recording into the meeting thread and hands it over
+// for transcription.
+func (lkp *LiveKitPlugin) onEgressEnded(info *livekit.EgressInfo) {
+	if info == nil || info.Status != livekit.EgressStatus_EGRESS_COMPLETE || info.GetFile() == nil {
+		return
+	}
+	file := info.GetFile()
+	meeting, err := lkp.getMeeting(meetingOfRoom(egressRoomName(info)))
+	if err != nil {
+		lkp.API.LogWarn("recording of unknown meeting", "egress", info.EgressId, "reason", err.Error())
+		return
+	}
+	record := &recording{
+		EgressID:  info.EgressId,
+		MeetingID: meeting.Id,
+		ChannelID: meeting.ChannelId,
+		Filename:  file.Filename,
+		Location:  file.Location,
+		Size:      file.Size,
+		EndedAt:   file.EndedAt,
+		CreateAt:  model.GetMillis(),
+	}
+	link := path.Base(file.Filename)
+	if file.Location != "" {
+		link = fmt.Sprintf("[%s](%s)", link, file.Location)
+	}
+	duration := time.Duration(file.EndedAt - file.StartedAt).Round(time.Second)
+	if reply := lkp.threadReply(meeting, fmt.Sprintf("Recording is ready: %s (%s)", link, duration)); reply != nil {
+		record.PostIDs = append(record.PostIDs, reply.Id)
+	}
+	err = lkp.saveRecording(record)
+	lkp.audit(auditEvent{Action: auditRecordingFinish, MeetingID: meeting.Id, ChannelID: meeting.ChannelId, Details: path.Base(record.Filename)}, err)
+	if err != nil {
+		lkp.API.LogError("recording not saved", "egress", record.EgressID, "reason", err.Error())
+		return
+	}
+	lkp.API.LogInfo("recording finished", "meeting", meeting.Id, "egress", record.EgressID, "file", record.Filename)
+
+	if lkp.getConfiguration().TranscriptionURL != "" {
+		lkp.transcribeRecording(meeting, record)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+	"github.com/pkg/errors"
+)
+
+// removeParticipant disconnects the participant from the meeting room.
+func (lkp *LiveKitPlugin) removeParticipant(meeting *model.Post, identity string) error {
+	_, err := lkp.master.RemoveParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: meeting.Id, Identity: identity})
+	if err != nil {
+		return errors.Wrap(err, "failed to remove participant")
+	}
+	lkp.API.LogInfo("participant removed", "meeting", meeting.Id, "identity", identity)
+	return nil
+}
+
+// UserHasLeftChannel removes the user from every running meeting of the channel.
+func (lkp *LiveKitPlugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
+	if err != nil {
+		lkp.API.LogWarn("rooms not listed", "reason", err.Error())
+		return
+	}
+	for _, room := range roomList.Rooms {
+		meeting, err := lkp.getMeeting(room.Name)
+		if err != nil || meeting.ChannelId != channelMember.ChannelId {
+			continue
+		}
+		_, err = lkp.master.GetParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: room.Name, Identity: channelMember.UserId})
+		if err != nil {
+			continue
+		}
+		err = lkp.removeParticipant(meeting, channelMember.UserId)
+		if err != nil {
+			lkp.API.LogError("participant not removed", "meeting", meeting.Id, "reason", err.Error())
+		}
+		event := auditEvent{Action: auditParticipantRemove, MeetingID: meeting.Id, ChannelID: meeting.ChannelId, TargetID: channelMember.UserId, Details: "left the channel"}
+		if actor != nil {
+			event.ActorID = actor.Id
+		}
+		lkp.audit(event, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const retentionKeyPrefix = "retention_"
+
+// retentionPolicy overrides the global recording retention for a single channel.
+// A legal hold keeps the recordings forever.
+type retentionPolicy struct {
+	Days      int  `json:"days"`
+	LegalHold bool `json:"legal_hold"`
+}
+
+func (policy retentionPolicy) String() string {
+	switch {
+	case policy.LegalHold:
+		return "kept forever (legal hold)"
+	case policy.Days > 0:
+		return fmt.Sprintf("deleted after %d days", policy.Days)
+	}
+	return "kept forever"
+}
+
+// shorter tells whether the policy deletes recordings earlier than the other one.
+func (policy retentionPolicy) shorter(other retentionPolicy) bool {
+	if policy.LegalHold || policy.Days <= 0 {
+		return false
+	}
+	return other.LegalHold || other.Days <= 0 || policy.Days < other.Days
+}
+
+// getRetention returns the policy of the channel, falling back to the global one.
+func (lkp *LiveKitPlugin) getRetention(channelID string) (retentionPolicy, bool, error) {
+	var policy *retentionPolicy
+	if err := lkp.sdk.KV.Get(retentionKeyPrefix+channelID, &policy); err != nil {
+		return retentionPolicy{}, false, errors.Wrap(err, "failed to read retention policy")
+	}
+	if policy != nil {
+		return *policy, true, nil
+	}
+	return retentionPolicy{Days: lkp.getConfiguration().RecordingRetentionDays}, false, nil
+}
+
+// cleanupRecordings is the scheduled job deleting the recordings whose retention has expired.
+// A deletion which fails is retried on every run, but audited only the first time.
+func (lkp *LiveKitPlugin) cleanupRecordings() {
+	keys, err := lkp.listKeys(recordingKeyPrefix)
+	if err != nil {
+		lkp.API.LogError("recordings not listed", "reason", err.Error())
+		return
+	}
+	policies := map[string]retentionPolicy{}
+	for _, key := range keys {
+		var record *recording
+		if err := lkp.sdk.KV.Get(key, &record); err != nil || record == nil {
+			continue
+		}
+		policy, found := policies[record.ChannelID]
+		if !found {
+			policy, _, err = lkp.getRetention(record.ChannelID)
+			if err != nil {
+				lkp.API.LogError("retention policy not read", "channel", record.ChannelID, "reason", err.Error())
+				continue
+			}
+			policies[record.ChannelID] = policy
+		}
+		if policy.LegalHold || policy.Days <= 0 {
+			continue
+		}
+		expiresAt := model.GetTimeForMillis(record.CreateAt).Add(time.Duration(policy.Days) * 24 * time.Hour)
+		if time.Now().Before(expiresAt) {
+			continue
+		}
+		err = lkp.deleteRecording(record)
+		if err != nil {
+			lkp.API.LogWarn("expired recording not deleted", "egress", record.EgressID, "reason", err.Error())
+			if record.DeleteFailedAt > 0 {
+				continue
+			}
+			record.DeleteFailedAt = model.GetMillis()
+			if saveErr := lkp.saveRecording(record); saveErr != nil {
+				lkp.API.LogError("recording not updated", "egress", record.EgressID, "reason", saveErr.Error())
+			}
+		}
+		lkp.audit(auditEvent{
+			Action:    auditRecordingDelete,
+			MeetingID: record.MeetingID,
+			ChannelID: record.ChannelID,
+			Details:   fmt.Sprintf("%s, recordings there are %s", path.Base(record.Filename), policy),
+		}, err)
+	}
+}
+
+// deleteRecording removes the recording file, the posts linking to it and its record.
+func (lkp *LiveKitPlugin) deleteRecording(record *recording) error {
+	if err := removeRecordingFile(record); err != nil {
+		return err
+	}
+	for _, postID := range record.PostIDs {
+		if appErr := lkp.API.DeletePost(postID); appErr != nil && appErr.StatusCode != http.StatusNotFound {
+			return errors.Wrapf(appErr, "failed to delete post %s", postID)
+		}
+	}
+	if err := lkp.sdk.KV.Delete(recordingKeyPrefix + record.EgressID); err != nil {
+		return errors.Wrap(err, "failed to delete recording record")
+	}
+	lkp.API.LogInfo("recording deleted", "egress", record.EgressID, "meeting", record.MeetingID)
+	return nil
+}
+
+// removeRecordingFile deletes a file written by the egress service to the shared filesystem,
+// or sends DELETE to its HTTP location. A file which is already gone counts as deleted.
+func removeRecordingFile(record *recording) error {
+	location := record.Location
+	if location == "" {
+		location = record.Filename
+	}
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		request, err := http.NewRequest(http.MethodDelete, location, nil)
+		if err != nil {
+			return err
+		}
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete recording file")
+		}
+		response.Body.Close()
+		if response.StatusCode >= 300 && response.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("recording file deletion failed with status %s", response.Status)
+		}
+		return nil
+	}
+	if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete recording file")
+	}
+	return nil
+}
+
+// executeRetention handles "/liveroom retention [days|forever|hold|default]" in the current
+// channel. Channel admins may only keep recordings longer, legal holds and shorter retention
+// are left to system admins.
+func (lkp *LiveKitPlugin) executeRetention(args *model.CommandArgs, params []string) string {
+	current, own, err := lkp.getRetention(args.ChannelId)
+	if err != nil {
+		return err.Error()
+	}
+	if len(params) == 0 {
+		if own {
+			return fmt.Sprintf("Recordings of this channel are %s.", current)
+		}
+		return fmt.Sprintf("This channel follows the global policy: recordings are %s.", current)
+	}
+	if !lkp.isChannelAdmin(args.ChannelId, args.UserId) {
+		return "Only channel and system admins can change the retention policy."
+	}
+	global := retentionPolicy{Days: lkp.getConfiguration().RecordingRetentionDays}
+	policy := retentionPolicy{}
+	switch params[0] {
+	case "default":
+		policy = global
+	case "forever":
+	case "hold":
+		policy.LegalHold = true
+	default:
+		policy.Days, err = strconv.Atoi(params[0])
+		if err != nil || policy.Days < 1 {
+			return "Usage: /liveroom retention [days|forever|hold|default]"
+		}
+	}
+	if !lkp.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) &&
+		(current.LegalHold || policy.LegalHold || policy.shorter(current) || policy.shorter(global)) {
+		err = newStatusError(http.StatusForbidden, "only system admins can set or lift a legal hold or shorten the retention")
+		lkp.audit(auditEvent{Action: auditRetentionChange, ActorID: args.UserId, ChannelID: args.ChannelId, Details: "recordings " + policy.String()}, err)
+		return "Only system admins can set or lift a legal hold or shorten the retention."
+	}
+	if params[0] == "default" {
+		err = lkp.sdk.KV.Delete(retentionKeyPrefix + args.ChannelId)
+		lkp.audit(auditEvent{Action: auditRetentionChange, ActorID: args.UserId, ChannelID: args.ChannelId, Details: "global policy"}, err)
+		if err == nil {
+			return "This channel follows the global retention policy now."
+		}
+		return err.Error()
+	}
+	_, err = lkp.sdk.KV.Set(retentionKeyPrefix+args.ChannelId, policy)
+	lkp.audit(auditEvent{Action: auditRetentionChange, ActorID: args.UserId, ChannelID: args.ChannelId, Details: "recordings " + policy.String()}, err)
+	if err != nil {
+		return err.Error()
+	}
+	lkp.API.LogInfo("retention policy changed", "channel", args.ChannelId, "user_id", args.UserId, "days", policy.Days, "hold", policy.LegalHold)
+	return fmt.Sprintf("Recordings of this channel are %s now.", policy)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// statusError is an error which knows the HTTP status it should be reported with.
+// Errors without a status are reported as internal errors.
+type statusError struct {
+	status  int
+	message string
+}
+
+func newStatusError(status int, format string, args ...interface{}) error {
+	return &statusError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+// errorStatus finds the HTTP status of the error, looking through the wrapped errors.
+func errorStatus(err error) int {
+	var coded interface{ StatusCode() int }
+	if errors.As(err, &coded) {
+		return coded.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+}
+
+type calendarURLResponse struct {
+	URL string `json:"url"`
+}
+
+type createMeetingRequest struct {
+	ChannelID string         `json:"channel_id"`
+	Capacity  uint32         `json:"capacity"`
+	Message   string         `json:"message"`
+	Options   meetingOptions `json:"options"`
+}
+
+type hostRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type breakoutStartRequest struct {
+	Rooms       int            `json:"rooms"`
+	Minutes     int            `json:"minutes"`
+	Assignments map[string]int `json:"assignments,omitempty"`
+}
+
+// writeJSON sends the data in the fetchResponse envelope every route of the API uses.
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(fetchResponse{Status: "OK", Data: data})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorStatus(err))
+	json.NewEncoder(w).Encode(fetchResponse{Status: "error", Error: err.Error()})
+}
+
+func decodeBody(r *http.Request, request interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return newStatusError(http.StatusBadRequest, "invalid request body: %s", err.Error())
+	}
+	return nil
+}
+
+func requestUserID(r *http.Request) string {
+	return r.Header.Get("Mattermost-User-ID")
+}
+
+// isPublicPath tells whether the route authenticates the request on its own: LiveKit signs
+// the webhooks, calendar feeds carry a secret token and the meeting page redirects to login.
+func isPublicPath(path string) bool {
+	return path == "/webhook" || path == "/calendar/feed" || strings.HasPrefix(path, "/meet/")
+}
+
+// newRouter maps the plugin routes. The JSON API lives under /api/v1, the unversioned
+// routes are addresses handed out to LiveKit, calendar applications and post actions.
+func (lkp *LiveKitPlugin) newRouter() http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newStatusError(http.StatusNotFound, "no route for %s", r.URL.Path))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newStatusError(http.StatusMethodNotAllowed, "method %s is not allowed on %s", r.Method, r.URL.Path))
+	})
+
+	router.HandleFunc("/webhook", lkp.handleWebhook).Methods(http.MethodPost)
+	router.HandleFunc("/calendar/feed", lkp.serveCalendarFeed).Methods(http.MethodGet)
+	router.HandleFunc("/meet/{id}", lkp.serveMeetingPage).Methods(http.MethodGet)
+	router.HandleFunc("/action", lkp.handleAction).Methods(http.MethodPost)
+	router.HandleFunc("/assets/channel-icon.png", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(lkp.bundlePath, "assets", "channel-icon.png"))
+	}).Methods(http.MethodGet)
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.json", serveOpenAPI).Methods(http.MethodGet)
+	api.HandleFunc("/settings", lkp.apiGetSettings).Methods(http.MethodGet)
+	api.HandleFunc("/rooms", lkp.apiListRooms).Methods(http.MethodGet)
+	api.HandleFunc("/calendar/url", lkp.apiCalendarURL).Methods(http.MethodGet)
+	api.HandleFunc("/audit", lkp.apiAudit).Methods(http.MethodGet)
+	api.HandleFunc("/history", lkp.apiHistory).Methods(http.MethodGet)
+	api.HandleFunc("/codes/{code}", lkp.apiMeetingCode).Methods(http.MethodGet)
+	api.HandleFunc("/meetings", lkp.apiCreateMeeting).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}", lkp.apiUpdateMeeting).Methods(http.MethodPatch)
+	api.HandleFunc("/meetings/{id}", lkp.apiDeleteMeeting).Methods(http.MethodDelete)
+	api.HandleFunc("/meetings/{id}/lock", lkp.apiLockMeeting(true)).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/lock", lkp.apiLockMeeting(false)).Methods(http.MethodDelete)
+	api.HandleFunc("/meetings/{id}/host", lkp.apiTransferHost).Methods(http.MethodPut)
+	api.HandleFunc("/meetings/{id}/cohosts/{user_id}", lkp.apiSetCoHost(true)).Methods(http.MethodPut)
+	api.HandleFunc("/meetings/{id}/cohosts/{user_id}", lkp.apiSetCoHost(false)).Methods(http.MethodDelete)
+	api.HandleFunc("/meetings/{id}/end", lkp.apiEndMeeting).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/invites", lkp.apiInvite).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/invites", lkp.apiListInvites).Methods(http.MethodGet)
+	api.HandleFunc("/meetings/{id}/join", lkp.apiJoinMeeting).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/refresh", lkp.apiRefreshToken).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/calendar", lkp.serveMeetingCalendar).Methods(http.MethodGet)
+	api.HandleFunc("/meetings/{id}/participants/{user_id}", lkp.apiRemoveParticipant).Methods(http.MethodDelete)
+	api.HandleFunc("/meetings/{id}/breakout", lkp.apiStartBreakout).Methods(http.MethodPost)
+	api.HandleFunc("/meetings/{id}/breakout", lkp.apiEndBreakout).Methods(http.MethodDelete)
+	api.HandleFunc("/meetings/{id}/breakout/token", lkp.apiBreakoutToken).Methods(http.MethodPost)
+
+	return lkp.recoverer(authenticate(router))
+}
+
+// recoverer turns a panic in a handler into a 500 reply instead of a dead plugin process.
+func (lkp *LiveKitPlugin) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				lkp.API.LogError("request handler panicked", "method", r.Method, "path", r.URL.Path, "reason", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+				writeError(w, newStatusError(http.StatusInternalServerError, "internal error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestUserID(r) == "" && !isPublicPath(r.URL.Path) {
+			writeError(w, newStatusError(http.StatusUnauthorized, "Not authorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+func (lkp *LiveKitPlugin) apiGetSettings(w http.ResponseWriter, r *http.Request) {
+	copy := *lkp.getConfiguration()
+	copy.ApiKey = "n/a"
+	copy.ApiValue = "n/a"
+	copy.TranscriptionKey = "n/a"
+	writeJSON(w, http.StatusOK, copy)
+}
+
+// apiListRooms lists the open rooms of the meetings the user may join, and every room to
+// system admins, as the room metadata tells the topic, channel and hosts of the meeting.
+func (lkp *LiveKitPlugin) apiListRooms(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	roomList, err := lkp.master.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
+	if err != nil {
+		writeError(w, newStatusError(http.StatusBadGateway, "failed to list rooms: %s", err.Error()))
+		return
+	}
+	if lkp.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		writeJSON(w, http.StatusOK, roomList.Rooms)
+		return
+	}
+	rooms := []*livekit.Room{}
+	membership := map[string]bool{}
+	for _, room := range roomList.Rooms {
+		meeting, err := lkp.getMeeting(meetingOfRoom(room.Name))
+		if err != nil {
+			continue
+		}
+		member, checked := membership[meeting.ChannelId]
+		if !checked {
+			_, appErr := lkp.API.GetChannelMember(meeting.ChannelId, userID)
+			member = appErr == nil
+			membership[meeting.ChannelId] = member
+		}
+		if member || lkp.isInvited(meeting.Id, userID) {
+			rooms = append(rooms, room)
+		}
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (lkp *LiveKitPlugin) apiCalendarURL(w http.ResponseWriter, r *http.Request) {
+	feedURL, err := lkp.calendarFeedURL(requestUserID(r), r.URL.Query().Get("reset") == "true")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, calendarURLResponse{URL: feedURL})
+}
+
+func (lkp *LiveKitPlugin) apiCreateMeeting(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	var request createMeetingRequest
+	if err := decodeBody(r, &request); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := lkp.canCreateMeeting(request.ChannelID, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !validOverflow(request.Options.Overflow) {
+		writeError(w, newStatusError(http.StatusBadRequest, "unknown overflow mode %q", request.Options.Overflow))
+		return
+	}
+	lkp.API.LogInfo("meeting requested", "user_id", userID, "channel_id", request.ChannelID)
+	meeting, appErr := lkp.createPost(request.ChannelID, userID, request.Message, request.Capacity, request.Options)
+	if appErr != nil {
+		writeError(w, appError(appErr))
+		return
+	}
+	writeJSON(w, http.StatusCreated, meeting)
+}
+
+func (lkp *LiveKitPlugin) apiUpdateMeeting(w http.ResponseWriter, r *http.Request) {
+	var update meetingUpdate
+	if err := decodeBody(r, &update); err != nil {
+		writeError(w, err)
+		return
+	}
+	meeting, err := lkp.updateMeeting(mux.Vars(r)["id"], requestUserID(r), update)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meeting)
+}
+
+func (lkp *LiveKitPlugin) apiLockMeeting(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, err := lkp.lockMeeting(mux.Vars(r)["id"], requestUserID(r), locked)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, meeting)
+	}
+}
+
+func (lkp *LiveKitPlugin) apiTransferHost(w http.ResponseWriter, r *http.Request) {
+	var request hostRequest
+	if err := decodeBody(r, &request); err != nil {
+		writeError(w, err)
+		return
+	}
+	meeting, err := lkp.transferHost(mux.Vars(r)["id"], requestUserID(r), request.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meeting)
+}
+
+func (lkp *LiveKitPlugin) apiSetCoHost(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		meeting, err := lkp.setCoHost(vars["id"], requestUserID(r), vars["user_id"], on)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, meeting)
+	}
+}
+
+func (lkp *LiveKitPlugin) apiEndMeeting(w http.ResponseWriter, r *http.Request) {
+	meeting, err := lkp.endMeeting(mux.Vars(r)["id"], requestUserID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meeting)
+}
+
+// apiDeleteMeeting removes the meeting post, which only its host or an admin may do.
+func (lkp *LiveKitPlugin) apiDeleteMeeting(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	meeting, err := lkp.getMeeting(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	event := auditEvent{Action: auditMeetingDelete, ActorID: userID, MeetingID: meeting.Id, ChannelID: meeting.ChannelId}
+	if !lkp.isHost(meeting, userID) && !lkp.isChannelAdmin(meeting.ChannelId, userID) {
+		err = newStatusError(http.StatusForbidden, "only the host can delete the meeting")
+		lkp.audit(event, err)
+		writeError(w, err)
+		return
+	}
+	lkp.API.LogInfo("meeting deletion requested", "user_id", userID, "meeting", meeting.Id)
+	if appErr := lkp.API.DeletePost(meeting.Id); appErr != nil {
+		lkp.audit(event, appErr)
+		writeError(w, appError(appErr))
+		return
+	}
+	lkp.audit(event, nil)
+	lkp.unscheduleMeeting(meeting.Id)
+	if code := codeOf(meeting); code != "" {
+		lkp.linkCode(code, "")
+	}
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (lkp *LiveKitPlugin) apiJoinMeeting(w http.ResponseWriter, r *http.Request) {
+	jwt, err := lkp.joinMeeting(mux.Vars(r)["id"], requestUserID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{Token: jwt})
+}
+
+// apiRefreshToken renews the token of a connected participant. When the user may no longer
+// take part, they are also disconnected from the room. Participants coming back from the
+// breakout rooms get the token of the main room, anyone else is handled as a new joiner.
+func (lkp *LiveKitPlugin) apiRefreshToken(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	userID := requestUserID(r)
+	meeting, user, err := lkp.authorizeMeeting(postID, userID)
+	if err != nil {
+		lkp.API.LogInfo("token refresh refused", "post_id", postID, "user_id", userID, "reason", err.Error())
+		// Failures to read the meeting or the membership don't cost the user their place.
+		if status := errorStatus(err); status == http.StatusForbidden || status == http.StatusNotFound || status == http.StatusGone {
+			lkp.master.RemoveParticipant(context.Background(), &livekit.RoomParticipantIdentity{Room: postID, Identity: userID})
+		}
+		writeError(w, err)
+		return
+	}
+	var jwt string
+	returning := false
+	connected := lkp.inRoom(meeting.Id, userID)
+	if !connected {
+		returning, err = lkp.returnsFromBreakout(meeting.Id, userID)
+	}
+	switch {
+	case err != nil:
+	case connected && lkp.listensTo(meeting.Id, userID):
+		jwt, err = lkp.listenerToken(meeting.Id, user)
+	case connected:
+		jwt, err = lkp.roomToken(meeting.Id, user)
+	case returning:
+		jwt, err = lkp.mainRoomToken(meeting, user)
+	default:
+		jwt, err = lkp.joinMeeting(meeting.Id, userID)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{Token: jwt})
+}
+
+func (lkp *LiveKitPlugin) apiRemoveParticipant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meeting, err := lkp.getMeeting(vars["id"])
+	if err == nil && !lkp.isHost(meeting, requestUserID(r)) {
+		err = newStatusError(http.StatusForbidden, "only the host can remove participants")
+	}
+	if err == nil {
+		err = lkp.removeParticipant(meeting, vars["user_id"])
+	}
+	lkp.audit(auditEvent{Action: auditParticipantRemove, ActorID: requestUserID(r), MeetingID: vars["id"], TargetID: vars["user_id"]}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (lkp *LiveKitPlugin) apiStartBreakout(w http.ResponseWriter, r *http.Request) {
+	var request breakoutStartRequest
+	if err := decodeBody(r, &request); err != nil {
+		writeError(w, err)
+		return
+	}
+	session, err := lkp.startBreakout(requestUserID(r), breakoutRequest{
+		PostID:      mux.Vars(r)["id"],
+		Rooms:       request.Rooms,
+		Minutes:     request.Minutes,
+		Assignments: request.Assignments,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+func (lkp *LiveKitPlugin) apiEndBreakout(w http.ResponseWriter, r *http.Request) {
+	if err := lkp.closeBreakout(mux.Vars(r)["id"], requestUserID(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (lkp *LiveKitPlugin) apiBreakoutToken(w http.ResponseWriter, r *http.Request) {
+	jwt, err := lkp.breakoutToken(mux.Vars(r)["id"], requestUserID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{Token: jwt})
+}
+
+// appError converts the errors of the plugin API, keeping their status.
+func appError(appErr *model.AppError) error {
+	status := appErr.StatusCode
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	return newStatusError(status, "%s", appErr.Error())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	statusKeyPrefix       = "status_"
+	statusOptOutKeyPrefix = "status_optout_"
+	statusEmoji           = "calendar"
+)
+
+// meetingStatus remembers the custom status a participant had before joining a room,
+// so it can be restored when they leave.
+type meetingStatus struct {
+	Room     string              `json:"room"`
+	Text     string              `json:"text"`
+	Previous *model.CustomStatus `json:"previous,omitempty"`
+}
+
+// onParticipantJoined sets the "In a meeting" status of the participant.
+func (lkp *LiveKitPlugin) onParticipantJoined(room *livekit.Room, participant *livekit.ParticipantInfo) {
+	if room == nil || participant == nil || participant.Identity == lkp.botUserID {
+		return
+	}
+	userID := participant.Identity
+	var optOut bool
+	if err := lkp.sdk.KV.Get(statusOptOutKeyPrefix+userID, &optOut); err != nil || optOut {
+		return
+	}
+	meeting, err := lkp.getMeeting(meetingOfRoom(room.Name))
+	if err != nil {
+		return
+	}
+	user, appErr := lkp.API.GetUser(userID)
+	if appErr != nil {
+		return
+	}
+
+	var saved *meetingStatus
+	if err := lkp.sdk.KV.Get(statusKeyPrefix+userID, &saved); err != nil {
+		lkp.API.LogError("meeting status not read", "user_id", userID, "reason", err.Error())
+		return
+	}
+	if saved == nil {
+		saved = &meetingStatus{Previous: user.GetCustomStatus()}
+	}
+	saved.Room = room.Name
+	saved.Text = truncateStatus(fmt.Sprintf("In a meeting: %s", meeting.Message))
+	if _, err := lkp.sdk.KV.Set(statusKeyPrefix+userID, saved); err != nil {
+		lkp.API.LogError("meeting status not saved", "user_id", userID, "reason", err.Error())
+		return
+	}
+	if appErr := lkp.API.UpdateUserCustomStatus(userID, &model.CustomStatus{Emoji: statusEmoji, Text: saved.Text}); appErr != nil {
+		lkp.API.LogError("meeting status not set", "user_id", userID, "reason", appErr.Error())
+	}
+}
+
+// onParticipantLeft restores the status the participant had before joining, unless they
+// have moved on to another room or changed the status on their own in the meantime.
+func (lkp *LiveKitPlugin) onParticipantLeft(room *livekit.Room, participant *livekit.ParticipantInfo) {
+	if room == nil || participant == nil || participant.Identity == lkp.botUserID {
+		return
+	}
+	userID := participant.Identity
+	var saved *meetingStatus
+	if err := lkp.sdk.KV.Get(statusKeyPrefix+userID, &saved); err != nil || saved == nil || saved.Room != room.Name {
+		return
+	}
+	if err := lkp.restoreStatus(userID, saved); err != nil {
+		lkp.API.LogError("meeting status not restored", "user_id", userID, "reason", err.Error())
+	}
+}
+
+func (lkp *LiveKitPlugin) restoreStatus(userID string, saved *meetingStatus) error {
+	if err := lkp.sdk.KV.Delete(statusKeyPrefix + userID); err != nil {
+		return err
+	}
+	user, appErr := lkp.API.GetUser(userID)
+	if appErr != nil {
+		return appErr
+	}
+	current := user.GetCustomStatus()
+	if current == nil || current.Emoji != statusEmoji || current.Text != saved.Text {
+		return nil
+	}
+	if saved.Previous == nil || (saved.Previous.Text == "" && saved.Previous.Emoji == "") {
+		appErr = lkp.API.RemoveUserCustomStatus(userID)
+	} else {
+		appErr = lkp.API.UpdateUserCustomStatus(userID, saved.Previous)
+	}
+	if appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// truncateStatus keeps the text within the custom status length limit.
+func truncateStatus(text string) string {
+	runes := []rune(text)
+	if len(runes) > model.CustomStatusTextMaxRunes {
+		return string(runes[:model.CustomStatusTextMaxRunes-1]) + "…"
+	}
+	return text
+}
+
+// executeStatus handles "/liveroom status on|off", the personal opt-out of meeting statuses.
+func (lkp *LiveKitPlugin) executeStatus(args *model.CommandArgs, params []string) string {
+	if len(params) != 1 || (params[0] != "on" && params[0] != "off") {
+		return "Usage: /liveroom status on|off"
+	}
+	var err error
+	if params[0] == "off" {
+		_, err = lkp.sdk.KV.Set(statusOptOutKeyPrefix+args.UserId, true)
+	} else {
+		err = lkp.sdk.KV.Delete(statusOptOutKeyPrefix + args.UserId)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to save the setting").Error()
+	}
+	if params[0] == "off" {
+		return "Your status won't be changed while you are in a meeting."
+	}
+	return "Your status will show the meeting you are in."
+}
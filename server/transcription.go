@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// httpClient talks to the recording storage and the speech-to-text service, so it allows
+// for transfers of recordings of any size.
+var httpClient = &http.Client{Timeout: 30 * time.Minute}
+
+// openRecording opens the recording either from its HTTP location or from the filesystem
+// shared with the egress service. The size is -1 when the storage doesn't tell it.
+func openRecording(record *recording) (io.ReadCloser, int64, error) {
+	if strings.HasPrefix(record.Location, "http://") || strings.HasPrefix(record.Location, "https://") {
+		response, err := httpClient.Get(record.Location)
+		if err != nil {
+			return nil, 0, err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, 0, fmt.Errorf("recording download failed with status %s", response.Status)
+		}
+		return response.Body, response.ContentLength, nil
+	}
+	name := record.Filename
+	if record.Location != "" {
+		name = record.Location
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// transcribe sends the audio to an OpenAI compatible /audio/transcriptions endpoint, such as
+// the one served by a local Whisper server, and returns the plain text transcript. The form is
+// streamed, so the recording is never held in memory.
+func (lkp *LiveKitPlugin) transcribe(audio io.Reader, filename string) (string, error) {
+	configuration := lkp.getConfiguration()
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	modelName := configuration.TranscriptionModel
+	if modelName == "" {
+		modelName = "whisper-1"
+	}
+	go func() {
+		form.WriteField("model", modelName)
+		form.WriteField("response_format", "text")
+		part, err := form.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, audio)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(errors.Wrap(err, "failed to send the recording"))
+	}()
+
+	url := strings.TrimSuffix(configuration.TranscriptionURL, "/") + "/audio/transcriptions"
+	request, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		body.Close()
+		return "", err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	if configuration.TranscriptionKey != "" {
+		request.Header.Set("Authorization", "Bearer "+configuration.TranscriptionKey)
+	}
+	response, err := httpClient.Do(request)
+	body.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "transcription request failed")
+	}
+	defer response.Body.Close()
+	text, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription failed with status %s: %s", response.Status, text)
+	}
+	return strings.TrimSpace(string(text)), nil
+}
+
+// transcribeRecording posts the transcript of a recording into the meeting thread, both as
+// a text file and as the text of an attachment, which the thread shows collapsed and the search
+// still finds.
+func (lkp *LiveKitPlugin) transcribeRecording(meeting *model.Post, record *recording) {
+	audio, size, err := openRecording(record)
+	if err != nil {
+		lkp.API.LogError("recording not readable", "egress", record.EgressID, "reason", err.Error())
+		return
+	}
+	defer audio.Close()
+	if size < 0 {
+		size = record.Size
+	}
+	if maxSize := int64(lkp.getConfiguration().TranscriptionMaxSizeMB) << 20; maxSize > 0 && size > maxSize {
+		lkp.API.LogWarn("recording too large to transcribe", "egress", record.EgressID, "size", size, "limit", maxSize)
+		lkp.threadReply(meeting, fmt.Sprintf("The recording is larger than %d MB, the speech-to-text service can't transcribe it.", maxSize>>20))
+		return
+	}
+	lkp.API.LogInfo("transcribing recording", "egress", record.EgressID)
+	text, err := lkp.transcribe(audio, path.Base(record.Filename))
+	if err != nil {
+		lkp.API.LogError("transcription failed", "egress", record.EgressID, "reason", err.Error())
+		return
+	}
+	if text == "" {
+		lkp.API.LogInfo("transcript is empty", "egress", record.EgressID)
+		return
+	}
+
+	base := strings.TrimSuffix(path.Base(record.Filename), path.Ext(record.Filename))
+	fileInfo, appErr := lkp.API.UploadFile([]byte(text), meeting.ChannelId, fmt.Sprintf("transcript-%s.txt", base))
+	if appErr != nil {
+		lkp.API.LogError("transcript not uploaded", "egress", record.EgressID, "reason", appErr.Error())
+		return
+	}
+	full := text
+	if runes := []rune(full); len(runes) > model.PostPropsMaxUserRunes/2 {
+		full = string(runes[:model.PostPropsMaxUserRunes/2]) + "\n\n*The transcript is cut, see the attached file.*"
+	}
+	post := &model.Post{
+		UserId:    lkp.botUserID,
+		ChannelId: meeting.ChannelId,
+		RootId:    meeting.Id,
+		Message:   "Transcript of the recording:",
+		FileIds:   model.StringArray{fileInfo.Id},
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Fallback: "Transcript of the recording", Text: full}})
+	reply, appErr := lkp.API.CreatePost(post)
+	if appErr != nil {
+		lkp.API.LogError("transcript not posted", "egress", record.EgressID, "reason", appErr.Error())
+		return
+	}
+	record.PostIDs = append(record.PostIDs, reply.Id)
+	if err := lkp.saveRecording(record); err != nil {
+		lkp.API.LogError("recording not updated", "egress", record.EgressID, "reason", err.Error())
+	}
+}
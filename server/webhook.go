@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/webhook"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// handleWebhook receives the events LiveKit posts to /plugins/<id>/webhook. The request is
+// signed with the API key and secret, so no Mattermost session is required.
+func (lkp *LiveKitPlugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	configuration := lkp.getConfiguration()
+	keys := auth.NewFileBasedKeyProviderFromMap(map[string]string{configuration.ApiKey: configuration.ApiValue})
+	data, err := webhook.Receive(r, keys)
+	if err != nil {
+		lkp.API.LogWarn("webhook rejected", "reason", err.Error())
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	event := &livekit.WebhookEvent{}
+	if err := protojson.Unmarshal(data, event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lkp.API.LogDebug("webhook received", "event", event.Event, "id", event.Id)
+
+	switch event.Event {
+	case webhook.EventRoomStarted:
+		go lkp.onRoomStarted(event.Room)
+		go lkp.onRoomLive(event.Room, true)
+	case webhook.EventRoomFinished:
+		go lkp.onRoomLive(event.Room, false)
+	case webhook.EventParticipantJoined:
+		go lkp.onParticipantJoined(event.Room, event.Participant)
+		go lkp.leaveQueue(event.Room, event.Participant)
+		go lkp.trackAttendance(event.Room, event.Participant, true)
+	case webhook.EventParticipantLeft:
+		go lkp.onParticipantLeft(event.Room, event.Participant)
+		go lkp.offerSeat(event.Room)
+		go lkp.onHostLeft(event.Room, event.Participant)
+		go lkp.trackAttendance(event.Room, event.Participant, false)
+		go lkp.releaseBridge(event.Room)
+	case webhook.EventEgressEnded:
+		go lkp.onEgressEnded(event.EgressInfo)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// egressRoomName finds the room an egress was recording.
+func egressRoomName(info *livekit.EgressInfo) string {
+	switch {
+	case info.GetWebComposite() != nil:
+		return info.GetWebComposite().GetRoomName()
+	case info.GetTrackComposite() != nil:
+		return info.GetTrackComposite().GetRoomName()
+	case info.GetTrack() != nil:
+		return info.GetTrack().GetRoomName()
+	}
+	return ""
+}
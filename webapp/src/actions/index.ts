@@ -13,20 +13,21 @@ export function fetchToken(postId:string): ActionFunc {
             console.log('fetchToken call with postId =', postId);
             const client = new Client4();
 
-            client.doFetch(`/plugins/${pluginId}/join`, {
-                body: JSON.stringify({post_id: postId}),
+            client.doFetch(`/plugins/${pluginId}/api/v1/meetings/${postId}/join`, {
                 method: 'POST',
                 credentials: 'include',
             }).then((response) => {
                 // @ts-ignore
                 if (response.status == "OK") {
                     // @ts-ignore
-                    dispatch({type: "TOKEN_RECEIVED", data: {id: postId, jwt: response.data}});
+                    dispatch({type: "TOKEN_RECEIVED", data: {id: postId, jwt: response.data.token}});
                     dispatch({type: "GO_LIVE", data: postId});
                 } else {
                     // @ts-ignore
                     console.log(`Token error: ${response.error}`);
                 }
+            }).catch((error) => {
+                console.log(`Token error: ${error.message}`);
             });
             return {data: "Ok"};
         } catch (error) {
@@ -39,11 +40,65 @@ export function fetchToken(postId:string): ActionFunc {
     };
 }
 
+// refreshToken renews the token of a connected participant. The server checks the membership
+// again and disconnects the user when they may no longer take part. In a breakout room the
+// token of that room is renewed instead.
+export function refreshToken(postId:string): ActionFunc {
+    return async (dispatch: DispatchFunc, getState: GetStateFunc): Promise<ActionResult> => {
+        // @ts-ignore
+        const inBreakout = Boolean(getState()[`plugins-${pluginId}`].breakouts[postId]);
+        const client = new Client4();
+        client.doFetch(`/plugins/${pluginId}/api/v1/meetings/${postId}/${inBreakout ? 'breakout/token' : 'refresh'}`, {
+            method: 'POST',
+            credentials: 'include',
+        }).then((response) => {
+            // @ts-ignore
+            if (response.status == "OK") {
+                // @ts-ignore
+                dispatch({type: "TOKEN_RECEIVED", data: {id: postId, jwt: response.data.token}});
+            } else {
+                // @ts-ignore
+                console.log(`Token refresh error: ${response.error}`);
+            }
+        }).catch((error) => {
+            console.log(`Token refresh error: ${error.message}`);
+        });
+        return {data: "Ok"};
+    };
+}
+
+// leaveBreakout fetches the token of the main room before going back there, the breakout room
+// being deleted.
+export function leaveBreakout(postId:string): ActionFunc {
+    return async (dispatch: DispatchFunc): Promise<ActionResult> => {
+        const client = new Client4();
+        client.doFetch(`/plugins/${pluginId}/api/v1/meetings/${postId}/refresh`, {
+            method: 'POST',
+            credentials: 'include',
+        }).then((response) => {
+            // @ts-ignore
+            if (response.status == "OK") {
+                // @ts-ignore
+                dispatch({type: "TOKEN_RECEIVED", data: {id: postId, jwt: response.data.token}});
+                dispatch({type: "BREAKOUT_LEFT", data: postId});
+            } else {
+                // @ts-ignore
+                console.log(`Main room token error: ${response.error}`);
+                dispatch({type: "GO_STILL", data: postId});
+            }
+        }).catch((error) => {
+            console.log(`Main room token error: ${error.message}`);
+            dispatch({type: "GO_STILL", data: postId});
+        });
+        return {data: "Ok"};
+    };
+}
+
 export function postMeeting(channelId:string): ActionFunc {
     return async (dispatch: DispatchFunc, getState: GetStateFunc): Promise<ActionResult> => {
         try {
             const client = new Client4();
-            client.doFetch(`/plugins/${pluginId}/create`, {
+            client.doFetch(`/plugins/${pluginId}/api/v1/meetings`, {
                 body: JSON.stringify({channel_id: channelId, message: getTranslation("room.topic")}),
                 method: 'POST',
                 credentials: 'include',
@@ -55,6 +110,8 @@ export function postMeeting(channelId:string): ActionFunc {
                     // @ts-ignore
                     console.log(`Hosting room error: ${response.error}`);
                 }
+            }).catch((error) => {
+                console.log(`Hosting room error: ${error.message}`);
             });
             return {data: "Ok"};
         } catch (error) {
@@ -67,13 +124,34 @@ export function deletePost(postId:string): ActionFunc {
     return async (dispatch: DispatchFunc, getState: GetStateFunc): Promise<ActionResult> => {
         try {
             const client = new Client4();
-            client.doFetch(`/plugins/${pluginId}/delete`, {
-                body: JSON.stringify({post_id: postId}),
-                method: 'POST',
+            client.doFetch(`/plugins/${pluginId}/api/v1/meetings/${postId}`, {
+                method: 'DELETE',
                 credentials: 'include',
             }).then((response) => {
                 // @ts-ignore
                 console.log('Post deleting response:', response);
+            }).catch((error) => {
+                console.log(`Post deleting error: ${error.message}`);
+            });
+            return {data: "Ok"};
+        } catch (error) {
+            return {error};
+        }
+    };
+}
+
+export function endMeeting(postId:string): ActionFunc {
+    return async (dispatch: DispatchFunc, getState: GetStateFunc): Promise<ActionResult> => {
+        try {
+            const client = new Client4();
+            client.doFetch(`/plugins/${pluginId}/api/v1/meetings/${postId}/end`, {
+                method: 'POST',
+                credentials: 'include',
+            }).then((response) => {
+                // @ts-ignore
+                console.log('Meeting ending response:', response);
+            }).catch((error) => {
+                console.log(`Meeting ending error: ${error.message}`);
             });
             return {data: "Ok"};
         } catch (error) {
@@ -86,7 +164,7 @@ export function getSettings(): ActionFunc {
     return async (dispatch: DispatchFunc): Promise<ActionResult> => {
         try {
             const client = new Client4();
-            client.doFetch(`/plugins/${pluginId}/settings`, {
+            client.doFetch(`/plugins/${pluginId}/api/v1/settings`, {
                 method: 'GET',
                 credentials: 'include',
             }).then((response) => {
@@ -95,7 +173,8 @@ export function getSettings(): ActionFunc {
                 console.log(response);
                 dispatch({
                     type: "CONFIG_RECEIVED",
-                    data: response
+                    // @ts-ignore
+                    data: response.data
                 });
             });
             return {data: "Ok"};
@@ -129,6 +208,18 @@ export function getTranslation(id: string) {
             ru: "Войти",
             en: "Enter",
         },
+        "room.end": {
+            ru: "Завершить для всех",
+            en: "End for everyone",
+        },
+        "room.ended": {
+            ru: "Встреча завершена",
+            en: "This meeting has ended",
+        },
+        "room.code": {
+            ru: "Код встречи:",
+            en: "Meeting code:",
+        },
         "room.topic": {
             ru: `${userName} приглашает в свою комнату`,
             en: `${userName} created live room`,
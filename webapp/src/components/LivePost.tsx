@@ -37,7 +37,7 @@ import Row from 'react-bootstrap/Row';
 import Col from 'react-bootstrap/Col';
 import ToggleButton from 'react-bootstrap/ToggleButton';
 import ToggleButtonGroup from 'react-bootstrap/ToggleButtonGroup';
-import {fetchToken, getTranslation, deletePost} from '../actions';
+import {fetchToken, refreshToken, leaveBreakout, getTranslation} from '../actions';
 import {id as pluginId} from '../manifest';
 
 import StillRoom from './StillRoom';
@@ -54,21 +54,37 @@ const stopPropagation = (e) => {
     console.log(e);
 };
 
+// Room tokens live for 10 minutes, refreshing them lets the server recheck the membership.
+const tokenRefreshInterval = 5 * 60 * 1000;
+
 const RoomView = (props: any) => {
     const dispatch = useDispatch();
-    const ttl = Math.abs((new Date() - new Date(props.post.create_at)) / (1000 * 60 *60));
-    if (ttl > 12) {
-        dispatch(deletePost(props.post.id));
-        return `liveKit post is ${Math.round(ttl)} hour(s) old, deleting...`;
-    }
     console.log(`rendering liveKit post with maxParticipants = ${props.post.props.room_capacity}, created by the ${props.post.props.room_host}`);
     const [displayOptions, setDisplayOptions] = React.useState<DisplayOptions>({stageLayout: 'grid', showStats: false});
     const updateOptions = (options: DisplayOptions) => setDisplayOptions({...displayOptions, ...options});
+
+    // The plugin sends "breakout" with the token of the assigned room and "breakout_end" when
+    // everyone goes back to the main room. A new room key reconnects LiveKitRoom.
+    const onSignal = (room: Room, payload: Uint8Array) => {
+        let message;
+        try {
+            message = JSON.parse(new TextDecoder().decode(payload));
+        } catch (error) {
+            return;
+        }
+        if (message.type === "breakout" && message.token) {
+            room.disconnect();
+            dispatch({type: "TOKEN_RECEIVED", data: {id: props.post.id, jwt: message.token}});
+            dispatch({type: "BREAKOUT_JOINED", data: {id: props.post.id, room: message.room}});
+        } else if (message.type === "breakout_end" && props.breakouts[props.post.id]) {
+            room.disconnect();
+            dispatch(leaveBreakout(props.post.id));
+        }
+    };
     return (<>
-        {!props.liveRooms[props.post.id] ?
+        {!props.liveRooms[props.post.id] || props.post.props.room_status === "ended" ?
             <StillRoom
                 post = {props.post}
-                token = {props.tokens[props.post.id]}
                 theme = {props.theme}
                 stopPropagation = {stopPropagation}>
             </StillRoom> :
@@ -84,6 +100,7 @@ const RoomView = (props: any) => {
                 // <DisplayContext.Provider value={displayOptions}>
                 // <div className="roomContainer" onClick = {stopPropagation}>
                     <LiveKitRoom
+                        key={props.breakouts[props.post.id] || props.post.id}
                         // https://livekit-users.slack.com/archives/C01KVTJH6BX/p1653607763178469
                         url={`wss://${props.pluginSettings.Host}:${props.pluginSettings.Port}`}
                         token={props.tokens[props.post.id]}
@@ -98,6 +115,9 @@ const RoomView = (props: any) => {
                         onConnected={(room) => {
                             setLogLevel('debug');
                             initialize(room);
+                            const refresher = setInterval(() => dispatch(refreshToken(props.post.id)), tokenRefreshInterval);
+                            room.once(RoomEvent.Disconnected, () => clearInterval(refresher));
+                            room.on(RoomEvent.DataReceived, (payload) => onSignal(room, payload));
                             // onConnected(room, query);
                         }}
                         onLeave={() => dispatch({type: "GO_STILL", data: props.post.id})}
@@ -368,6 +388,7 @@ function mapStateToProps(state, ownProps) {
         ...ownProps,
         tokens: state[`plugins-${pluginId}`].tokens,
         liveRooms: state[`plugins-${pluginId}`].liveRooms,
+        breakouts: state[`plugins-${pluginId}`].breakouts,
         pluginSettings: state[`plugins-${pluginId}`].config,
         // currentLocale: getCurrentUserLocale(state),
         // useSVG: !isMinimumServerVersion(getServerVersion(state), 5, 24),
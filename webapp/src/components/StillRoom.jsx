@@ -3,20 +3,29 @@ import {connect, useSelector, useDispatch} from 'react-redux';
 import {getTheme} from 'mattermost-redux/selectors/entities/preferences';
 import {makeStyleFromTheme} from 'mattermost-redux/utils/theme_utils';
 
-import {fetchToken, getTranslation} from '../actions';
+import {getCurrentUserId} from 'mattermost-redux/selectors/entities/users';
+
+import {fetchToken, endMeeting, getTranslation} from '../actions';
 import {id as pluginId} from '../manifest';
 
 const StillRoom = (props) => {
     const dispatch = useDispatch();
     const buttonLabel = getTranslation("room.connect");
     const style = getStyle(props.theme);
-    const goLive = () => props.token ? dispatch({type: "GO_LIVE", data: props.post.id}) : dispatch(fetchToken(props.post.id));
+    const goLive = () => dispatch(fetchToken(props.post.id));
+    const ended = props.post.props.room_status === "ended";
+    const isHost = props.post.props.room_host === props.currentUserId || (props.post.props.room_cohosts || []).includes(props.currentUserId);
     return (
         <div style={style.wrapper} onClick = {props.stopPropagation}>
-            <div style={style.message}>{props.post.message}</div>
-            <div style={style.buttonWrapper}>
-                <div style={style.connectButton} className = "btn btn-lg btn-primary" onClick = {goLive}>{buttonLabel}</div>
+            <div style={style.message}>
+                {props.post.message}
+                {!ended && props.post.props.room_code && <div style={style.badge}>{getTranslation("room.code")} <code>{props.post.props.room_code}</code></div>}
+                {ended && <div style={style.badge}>{props.post.props.room_summary || getTranslation("room.ended")}</div>}
             </div>
+            {!ended && <div style={style.buttonWrapper}>
+                <div style={style.connectButton} className = "btn btn-lg btn-primary" onClick = {goLive}>{buttonLabel}</div>
+                {isHost && <div className = "btn btn-link" onClick = {() => dispatch(endMeeting(props.post.id))}>{getTranslation("room.end")}</div>}
+            </div>}
         </div>
     );
 }
@@ -36,10 +45,16 @@ const getStyle = makeStyleFromTheme((theme) => {
             padding: '10px',
             borderLeftColor: '#89AECB'
         },
+        badge: {
+            marginTop: '6px',
+            fontSize: '12px',
+            opacity: 0.72,
+        },
         buttonWrapper: {
             width: "20%",
             display: "flex",
-            justifyContent: "center",
+            flexDirection: "column",
+            alignItems: "center",
         },
         connectButton: {
             color: theme.buttonColor,
@@ -56,6 +71,7 @@ function mapStateToProps(state, ownProps) {
     return {
         ...ownProps,
         // theme: getTheme(state),
+        currentUserId: getCurrentUserId(state),
         tokens: state[`plugins-${pluginId}`].tokens,
         pluginSettings: state[`plugins-${pluginId}`].config,
     };
@@ -28,6 +28,30 @@ function tokens(state: object = {}, action: {type: string, data: object}) {
         newSet[action.data.id] = action.data.jwt;
         console.log(newSet);
         return newSet;
+    case "GO_STILL":
+        // Tokens are short-lived, the next join fetches a new one.
+        let leftSet = {...state};
+        // @ts-ignore
+        delete leftSet[action.data];
+        return leftSet;
+    default:
+        return state;
+    }
+}
+
+// breakouts maps a meeting to the breakout room the user was moved to.
+function breakouts(state: object = {}, action: {type: string, data: any}) {
+    let newSet = {...state};
+    switch (action.type) {
+    case "BREAKOUT_JOINED":
+        // @ts-ignore
+        newSet[action.data.id] = action.data.room;
+        return newSet;
+    case "BREAKOUT_LEFT":
+    case "GO_STILL":
+        // @ts-ignore
+        delete newSet[action.data];
+        return newSet;
     default:
         return state;
     }
@@ -46,5 +70,6 @@ function config(state: object = {}, action: {type: string, data: object}) {
 export default combineReducers({
     liveRooms,
     tokens,
+    breakouts,
     config
 });